@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// writeDepthReport writes one line per package with its depth in the
+// dependency graph, shallowest first and then by import path. Packages that
+// are not reachable from any matched package (depth -1) come last.
+func writeDepthReport(w io.Writer, depths map[string]int) error {
+	pkgs := make([]string, 0, len(depths))
+	for pkg := range depths {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		di, dj := depths[pkgs[i]], depths[pkgs[j]]
+		if di != dj {
+			if di < 0 || dj < 0 {
+				return dj < 0
+			}
+			return di < dj
+		}
+		return pkgs[i] < pkgs[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPTH\tPACKAGE")
+	for _, pkg := range pkgs {
+		depth := fmt.Sprint(depths[pkg])
+		if depths[pkg] < 0 {
+			depth = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", depth, pkg)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDepthReport(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeDepthReport(&buf, map[string]int{
+		"example.com/c":      2,
+		"example.com/orphan": -1,
+		"example.com/a":      0,
+		"example.com/b2":     1,
+		"example.com/b1":     1,
+	}))
+
+	want := `DEPTH  PACKAGE
+0      example.com/a
+1      example.com/b1
+1      example.com/b2
+2      example.com/c
+-      example.com/orphan
+`
+	assert.Equal(t, want, buf.String())
+}
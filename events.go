@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// eventLog writes newline-delimited JSON events describing the run. A nil
+// eventLog discards all events.
+type eventLog struct {
+	enc   *json.Encoder
+	c     io.Closer
+	start time.Time
+}
+
+type startedEvent struct {
+	Event   string            `json:"event"`
+	Version string            `json:"version"`
+	Config  map[string]string `json:"config"`
+}
+
+type countEvent struct {
+	Event string `json:"event"`
+	Count int    `json:"count"`
+}
+
+type doneEvent struct {
+	Event      string  `json:"event"`
+	DurationMS int64   `json:"duration_ms"`
+	Error      *string `json:"error"`
+}
+
+// openEventLog creates an event log writing to path, or returns nil if path is empty
+func openEventLog(path string) (*eventLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	l := newEventLog(f)
+	l.c = f
+	return l, nil
+}
+
+func newEventLog(w io.Writer) *eventLog {
+	return &eventLog{
+		enc:   json.NewEncoder(w),
+		start: time.Now(),
+	}
+}
+
+func (l *eventLog) started(version string, config map[string]string) {
+	l.emit(startedEvent{Event: "started", Version: version, Config: config})
+}
+
+func (l *eventLog) packagesFound(count int) {
+	l.emit(countEvent{Event: "packages_found", Count: count})
+}
+
+func (l *eventLog) filesKept(count int) {
+	l.emit(countEvent{Event: "files_kept", Count: count})
+}
+
+// done emits the final event and closes the log
+func (l *eventLog) done(err error) {
+	if l == nil {
+		return
+	}
+
+	ev := doneEvent{Event: "done", DurationMS: time.Since(l.start).Milliseconds()}
+	if err != nil {
+		msg := err.Error()
+		ev.Error = &msg
+	}
+	l.emit(ev)
+
+	if l.c != nil {
+		l.c.Close()
+	}
+}
+
+func (l *eventLog) emit(ev any) {
+	if l == nil {
+		return
+	}
+	// Events are best effort and must not abort the run
+	_ = l.enc.Encode(ev)
+}
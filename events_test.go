@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeEvents(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &ev))
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestEventLog_Sequence(t *testing.T) {
+	var buf bytes.Buffer
+	l := newEventLog(&buf)
+
+	l.started("v1.2.3", map[string]string{"dir": "/src"})
+	l.packagesFound(12)
+	l.filesKept(34)
+	l.done(nil)
+
+	events := decodeEvents(t, &buf)
+	require.Len(t, events, 4)
+
+	assert.Equal(t, "started", events[0]["event"])
+	assert.Equal(t, "v1.2.3", events[0]["version"])
+	assert.Equal(t, map[string]any{"dir": "/src"}, events[0]["config"])
+
+	assert.Equal(t, map[string]any{"event": "packages_found", "count": float64(12)}, events[1])
+	assert.Equal(t, map[string]any{"event": "files_kept", "count": float64(34)}, events[2])
+
+	assert.Equal(t, "done", events[3]["event"])
+	assert.Contains(t, events[3], "duration_ms")
+	assert.Contains(t, events[3], "error")
+	assert.Nil(t, events[3]["error"])
+}
+
+func TestEventLog_DoneWithError(t *testing.T) {
+	var buf bytes.Buffer
+	l := newEventLog(&buf)
+	l.done(errors.New("failed to find packages"))
+
+	events := decodeEvents(t, &buf)
+	require.Len(t, events, 1)
+	assert.Equal(t, "failed to find packages", events[0]["error"])
+}
+
+func TestEventLog_Nil(t *testing.T) {
+	var l *eventLog
+	assert.NotPanics(t, func() {
+		l.started("dev", nil)
+		l.packagesFound(1)
+		l.filesKept(1)
+		l.done(nil)
+	})
+}
@@ -2,25 +2,106 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/sigma/monorepo-hatchet/pkg/cleaner"
 	"github.com/sigma/monorepo-hatchet/pkg/pkglist"
+	"github.com/sigma/monorepo-hatchet/pkg/report"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	sourceDir := flag.String("dir", "", "Source directory to analyze")
 	packagePatterns := flag.String("packages", "", "Comma-separated list of packages to keep")
+	patternsFile := flag.String("patterns-file", "", "File listing packages to keep, one pattern per line, in addition to --packages (blank lines and lines starting with # are ignored)")
+	gitDiffRange := flag.String("package-list-from-git-diff", "", "Keep the packages containing files changed in this git range (e.g. HEAD~3..HEAD)")
+	var excludePatterns []string
+	flag.Func("exclude", "Comma-separated list of packages to remove from the keep set once dependencies are added, unless other kept packages need them (repeatable)", func(value string) error {
+		for _, p := range strings.Split(value, ",") {
+			if p = strings.TrimSuffix(strings.TrimSpace(p), "/"); p != "" {
+				excludePatterns = append(excludePatterns, p)
+			}
+		}
+		return nil
+	})
+	includeDirs := flag.String("dirs", "", "Comma-separated list of directories (absolute or relative to source directory) whose packages to keep")
+	includePatterns := flag.String("include-patterns", "", "Comma-separated list of globs matched against package directories (relative to source directory) to keep")
 	withTests := flag.Bool("with-tests", false, "Include test files for kept packages")
 	protectGit := flag.Bool("protect-git", true, "Protect .git directories from being cleaned")
 	protectGoMod := flag.Bool("protect-gomod", true, "Protect go.mod and go.sum files from being cleaned")
-	protectFiles := flag.String("protect-files", "", "Comma-separated list of files/directories to protect (paths relative to source directory)")
+	protectGoWork := flag.Bool("protect-gowork", true, "Protect go.work and go.work.sum files from being cleaned")
+	protectFiles := flag.String("protect-files", "", "Comma-separated list of files/directories to protect (paths relative to source directory); defaults to the colon-separated $HATCHET_PROTECTED_PATHS")
+	aggressive := flag.Bool("aggressive", false, "Remove test files from kept packages unless --with-tests is set")
+	preserveDirs := flag.Bool("preserve-dirs", false, "Keep directories that are left empty after cleaning")
+	generateWorkspace := flag.Bool("generate-workspace", false, "Write a go.work using the modules of the kept packages to the cleaned tree")
+	checkOwner := flag.Bool("check-owner", false, "Skip files not owned by the current user instead of trying to remove them")
+	touchKept := flag.Bool("touch-kept", false, "Update the modification time of kept files after cleaning")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Clean the files inside symlinked directories instead of treating symlinks as files")
+	trashDir := flag.String("trash-dir", "", "Move removed files under this directory instead of deleting them")
+	outputDir := flag.String("output-dir", "", "Copy kept files to this directory instead of removing files from the source directory")
+	mirror := flag.Bool("mirror", false, "With --output-dir, mirror the directory structure and file permissions exactly")
+	runVet := flag.Bool("vet", false, "Run go vet on the cleaned tree")
+	runStaticcheck := flag.Bool("staticcheck", false, "Run staticcheck on the cleaned tree")
+	keepConstrained := flag.Bool("preserve-build-constraint-files", false, "Keep Go files excluded from the current build by build constraints")
+	manifest := flag.Bool("manifest", false, "Write a MANIFEST.txt listing kept files with their SHA-256 hashes and sizes")
+	checksumVerify := flag.String("checksum-verify", "", "Abort if kept files do not match their hashes in this manifest (as written by --manifest)")
+	hardLinkDedup := flag.Bool("hardlink-dedup", false, "With --output-dir, hard-link identical kept files")
+	keepRecent := flag.Duration("keep-recently-modified", 0, "Always keep files modified within this duration (e.g. 24h)")
+	warnNewFiles := flag.Bool("warn-uncommitted", false, "Warn before removing files modified after the last git commit")
+	gitAdd := flag.Bool("git-add", false, "Stage removed files in the git index")
+	parallelRemove := flag.Int("parallel-remove", 1, "Number of files to remove concurrently")
+	preserveTypes := flag.String("preserve-content-types", "", "Comma-separated list of MIME types (e.g. image/png) of files to always keep")
+	buildVerify := flag.Bool("build-verify", false, "Check that the tree builds without the files to remove before removing them")
 	dryRun := flag.Bool("dry-run", false, "Don't actually remove files, just show what would be done")
+	expandPatterns := flag.Bool("expand-patterns", false, "Resolve short package patterns to their full import path")
+	dryRunFile := flag.String("dry-run-file", "", "Write the JSON report of the run to this file, or to stdout if -")
+	excludeInternal := flag.Bool("exclude-cross-module-internal", false, "Skip internal packages imported from outside their allowed tree")
+	explain := flag.String("explain", "", "Comma-separated list of import paths to explain why they are kept or not")
+	listUnused := flag.Bool("list-unused", false, "Print packages that are not kept")
+	depthReport := flag.Bool("depth-report", false, "Print each kept package with its depth in the dependency graph from the matched packages")
+	warnOrphans := flag.Bool("warn-orphans", false, "Warn about kept packages that are not reachable from any matched package")
+	autoDownload := flag.Bool("auto-download", false, "Run go mod download before listing packages")
+	maxFiles := flag.Int("filter-by-size", 0, "Exclude kept packages with more than this many source files (0 for no limit)")
+	moduleGraph := flag.Bool("module-graph", false, "Keep every package of the modules reachable in go mod graph instead of following package dependencies")
+	deepEmbedScan := flag.Bool("deep-embed-scan", false, "Keep the whole tree of directories embedded with //go:embed")
+	scanNonGo := flag.String("scan-non-go", "", "Comma-separated list of extensions (e.g. .proto,.graphql) of files to keep in the directories of kept packages")
+	pinGoVersion := flag.String("pin-go-version", "", "Exclude matched packages whose module requires a newer Go version than this (e.g. 1.20)")
+	ignoreReplace := flag.Bool("ignore-replace", false, "List packages as if the go.mod of the source directory had no replace directives")
+	prebuiltBinaries := flag.Bool("include-prebuilt-binaries", false, "Keep executable files found in bin directories")
+	platform := flag.String("platform-filter", "", "List packages as if targeting this GOOS/GOARCH (e.g. linux/amd64)")
+	summary := flag.Bool("summary", false, "Print a summary of the clean to stderr")
+	diffTree := flag.Bool("diff-tree", false, "Print a unified diff of the directory tree before and after the clean")
+	eventLogPath := flag.String("event-log", "", "Write newline-delimited JSON events describing the run to this file")
 	flag.Parse()
 
+	events, err := openEventLog(*eventLogPath)
+	if err != nil {
+		log.Fatalf("Failed to open event log: %v", err)
+	}
+	events.started(version, flagConfig())
+
+	fatalf := func(format string, args ...any) {
+		err := fmt.Errorf(format, args...)
+		events.done(err)
+		log.Fatal(err)
+	}
+
 	patterns := strings.Split(*packagePatterns, ",")
+	if *patternsFile != "" {
+		filePatterns, err := readPatternsFile(*patternsFile)
+		if err != nil {
+			fatalf("Failed to read patterns file: %v", err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
 	if len(patterns) == 0 {
 		flag.Usage()
 		return
@@ -32,7 +113,17 @@ func main() {
 	}
 
 	if *sourceDir == "" {
-		log.Fatalf("Source directory is required")
+		fatalf("Source directory is required")
+	}
+
+	var nonEmpty []string
+	for _, p := range patterns {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	if _, err := (pkglist.PatternParser{}).Parse(nonEmpty); err != nil {
+		fatalf("Invalid package patterns: %v", err)
 	}
 
 	// Process protected file paths
@@ -45,41 +136,232 @@ func main() {
 		}
 	}
 
+	var contentTypes []string
+	if *preserveTypes != "" {
+		contentTypes = strings.Split(*preserveTypes, ",")
+		for i, t := range contentTypes {
+			contentTypes[i] = strings.TrimSpace(t)
+		}
+	}
+
 	absSourceDir, err := filepath.Abs(*sourceDir)
 	if err != nil {
-		log.Fatalf("Failed to get absolute path: %v", err)
+		fatalf("Failed to get absolute path: %v", err)
+	}
+
+	absOutputDir := *outputDir
+	if absOutputDir != "" {
+		absOutputDir, err = filepath.Abs(absOutputDir)
+		if err != nil {
+			fatalf("Failed to get absolute path: %v", err)
+		}
+	}
+
+	absTrashDir := *trashDir
+	if absTrashDir != "" {
+		absTrashDir, err = filepath.Abs(absTrashDir)
+		if err != nil {
+			fatalf("Failed to get absolute path: %v", err)
+		}
 	}
 
 	// Step 1: Find all packages
-	finder := pkglist.NewFinder(absSourceDir)
+	finderOpts := []pkglist.Option{
+		pkglist.WithExpandPatterns(*expandPatterns),
+		pkglist.WithExcludeCrossModuleInternal(*excludeInternal),
+		pkglist.WithAutoDownload(*autoDownload),
+		pkglist.WithMaxFilesPerPackage(*maxFiles),
+		pkglist.WithModuleGraph(*moduleGraph),
+		pkglist.WithDeepEmbedScan(*deepEmbedScan),
+		pkglist.WithIncludePrebuiltBinaries(*prebuiltBinaries),
+		pkglist.WithIgnoreReplace(*ignoreReplace),
+	}
+	if *scanNonGo != "" {
+		exts := strings.Split(*scanNonGo, ",")
+		for i, ext := range exts {
+			exts[i] = strings.TrimSpace(ext)
+		}
+		finderOpts = append(finderOpts, pkglist.WithScanNonGo(exts))
+	}
+	if *pinGoVersion != "" {
+		if !pkglist.ValidGoVersion(*pinGoVersion) {
+			fatalf("Invalid Go version %q", *pinGoVersion)
+		}
+		finderOpts = append(finderOpts, pkglist.WithMinGoVersion(*pinGoVersion))
+	}
+	if *platform != "" {
+		goos, goarch, ok := strings.Cut(*platform, "/")
+		if !ok {
+			fatalf("Invalid platform %q, expected GOOS/GOARCH", *platform)
+		}
+		finderOpts = append(finderOpts, pkglist.WithPlatform(goos, goarch))
+	}
+	finder := pkglist.NewFinder(absSourceDir, finderOpts...)
 	if err := finder.FindAll(); err != nil {
-		log.Fatalf("Failed to find packages: %v", err)
+		fatalf("Failed to find packages: %v", err)
+	}
+	events.packagesFound(len(finder.Packages()))
+
+	if *gitDiffRange != "" {
+		changed, err := finder.PackagesFromGitDiff(*gitDiffRange)
+		if err != nil {
+			fatalf("Failed to find changed packages: %v", err)
+		}
+		for pkg := range changed {
+			patterns = append(patterns, pkg)
+		}
 	}
 
 	// Step 2: Filter packages based on patterns
 	keepPackages := finder.FilterByPatterns(patterns)
+	if *includePatterns != "" {
+		globs := strings.Split(*includePatterns, ",")
+		for i, g := range globs {
+			globs[i] = strings.TrimSpace(g)
+		}
+		for pkg := range finder.FilterByGlobs(globs) {
+			keepPackages[pkg] = struct{}{}
+		}
+	}
+
+	if *includeDirs != "" {
+		for pkg := range finder.FilterByDirectory(splitList(*includeDirs)) {
+			keepPackages[pkg] = struct{}{}
+		}
+	}
+
+	finder.FilterByNegation(keepPackages, patterns)
 
 	// Step 3: Add dependencies
 	finder.AddDependencies(keepPackages)
+	if len(excludePatterns) > 0 {
+		finder.ExcludePackages(keepPackages, excludePatterns)
+	}
+
+	if *depthReport {
+		if err := writeDepthReport(os.Stdout, finder.DepthReport(keepPackages)); err != nil {
+			fatalf("Failed to write depth report: %v", err)
+		}
+	}
+
+	if *warnOrphans {
+		for _, pkg := range finder.FindOrphans() {
+			log.Printf("Warning: package %s is kept but not reachable from any matched package", pkg.ImportPath)
+		}
+	}
+
+	if *explain != "" {
+		for _, importPath := range strings.Split(*explain, ",") {
+			importPath = strings.TrimSpace(importPath)
+			fmt.Printf("%s: %s\n", importPath, finder.Explain(importPath))
+		}
+	}
+
+	if *listUnused {
+		for _, pkg := range finder.ListUnused(keepPackages) {
+			fmt.Println(pkg.ImportPath)
+		}
+	}
 
 	// Step 4: Build list of files to keep
-	allFiles := finder.GetFileList(keepPackages, *withTests)
+	allFiles := finder.GetFileList(keepPackages, pkglist.GetFileListOptions{WithTests: *withTests})
+	allFiles = append(allFiles, finder.GetNonGoFileList(keepPackages)...)
 
 	log.Printf("Total files to keep: %d", len(allFiles))
+	events.filesKept(len(allFiles))
 	for _, f := range allFiles {
 		log.Printf("  Keeping: %s", f)
 	}
 
+	// Run go mod tidy once per module rather than only in the source directory
+	var moduleDirs []string
+	for goMod := range finder.PackagesByMod() {
+		dir := filepath.Dir(goMod)
+		if rel, err := filepath.Rel(absSourceDir, dir); err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		moduleDirs = append(moduleDirs, dir)
+	}
+	sort.Strings(moduleDirs)
+
+	mode := cleaner.ModeConservative
+	if *aggressive {
+		mode = cleaner.ModeAggressive
+	}
+
+	var treeDiffOutput io.Writer
+	if *diffTree {
+		treeDiffOutput = os.Stdout
+	}
+
 	// Step 5: Clean
-	c := cleaner.New(*sourceDir, allFiles,
+	c := cleaner.New(absSourceDir, allFiles,
 		cleaner.WithGitProtection(*protectGit),
 		cleaner.WithGoModProtection(*protectGoMod),
+		cleaner.WithWorkspaceProtection(*protectGoWork),
 		cleaner.WithTestKeeping(*withTests),
 		cleaner.WithDryRun(*dryRun),
+		cleaner.WithCleaning(mode),
+		cleaner.WithPreserveDirectoryStructure(*preserveDirs),
+		cleaner.WithOutputDir(absOutputDir),
+		cleaner.WithTrashDir(absTrashDir),
+		cleaner.WithSymlinkFollowing(*followSymlinks),
+		cleaner.WithTouchKeptFiles(*touchKept),
+		cleaner.WithOwnershipCheck(*checkOwner),
+		cleaner.WithMirrorMode(*mirror),
+		cleaner.WithHardLinkDedup(*hardLinkDedup),
+		cleaner.WithRemovedPackages(finder.RemovedPackages()),
+		cleaner.WithDryRunFile(*dryRunFile),
 		cleaner.WithGoModTidy(true),
+		cleaner.WithModuleDirs(moduleDirs),
+		cleaner.WithRunVet(*runVet),
+		cleaner.WithRunStaticcheck(*runStaticcheck),
+		cleaner.WithPreserveBuildConstraintFiles(*keepConstrained),
+		cleaner.WithManifest(*manifest),
+		cleaner.WithChecksumVerify(*checksumVerify),
+		cleaner.WithKeepRecentlyModified(*keepRecent),
+		cleaner.WithGitNewFileWarning(*warnNewFiles),
+		cleaner.WithGitAdd(*gitAdd),
+		cleaner.WithConcurrency(*parallelRemove),
+		cleaner.WithPreserveByContentType(contentTypes),
+		cleaner.WithBuildVerify(*buildVerify),
 		cleaner.WithProtectedPaths(protectedPaths),
+		cleaner.WithDiffTree(treeDiffOutput),
 	)
-	if err := c.Clean(); err != nil {
-		log.Fatalf("Failed to clean directory: %v", err)
+	cleanSummary, err := c.Clean()
+	if err != nil {
+		fatalf("Failed to clean directory: %v", err)
 	}
+	log.Printf("Removed %d files (%d bytes) and %d empty directories, kept %d files",
+		cleanSummary.FilesRemoved, cleanSummary.BytesFreed, cleanSummary.DirsRemoved, cleanSummary.FilesKept)
+
+	if *generateWorkspace {
+		resultDir := absSourceDir
+		if absOutputDir != "" {
+			resultDir = absOutputDir
+		}
+		workFile := filepath.Join(resultDir, "go.work")
+		if *dryRun {
+			log.Printf("Dry run: skipping writing %s", workFile)
+		} else if err := finder.GenerateWorkFile(keepPackages, workFile); err != nil {
+			fatalf("Failed to generate %s: %v", workFile, err)
+		}
+	}
+
+	if *summary {
+		if err := report.TextSummary(c.Report(), os.Stderr); err != nil {
+			fatalf("Failed to write summary: %v", err)
+		}
+	}
+
+	events.done(nil)
+}
+
+// flagConfig returns the value of every flag, keyed by flag name
+func flagConfig() map[string]string {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+	return config
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// readPatternsFile reads package patterns from the file at path, one per line.
+// Blank lines and lines starting with "#" are ignored.
+func readPatternsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// splitList splits a comma-separated flag value, trimming spaces and dropping
+// empty entries such as the one left by a trailing comma
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPatternsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns.txt")
+	require.NoError(t, os.WriteFile(path, []byte(`# Services
+github.com/org/repo/op-node
+
+  ./op-batcher/...
+	# Shared libraries
+op-service/...
+!op-service/legacy
+
+name:main
+`), 0644))
+
+	patterns, err := readPatternsFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"github.com/org/repo/op-node",
+		"./op-batcher/...",
+		"op-service/...",
+		"!op-service/legacy",
+		"name:main",
+	}, patterns)
+}
+
+func TestReadPatternsFile_Missing(t *testing.T) {
+	_, err := readPatternsFile(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"services/api", "libs"}, splitList("services/api, libs,"))
+	assert.Equal(t, []string{"."}, splitList(" . "))
+	assert.Nil(t, splitList(" , ,"))
+}
@@ -0,0 +1,251 @@
+// Package analyzer finds //go:embed and //go:linkname directives in Go files.
+// Both reference files outside the usual import graph, so they must be taken
+// into account when deciding which files to keep.
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/afero"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/checker"
+	"golang.org/x/tools/go/packages"
+)
+
+// Directive kinds
+const (
+	KindEmbed    = "embed"
+	KindLinkname = "linkname"
+)
+
+// Reference is a //go:embed or //go:linkname directive found in a Go file
+type Reference struct {
+	File string
+	Line int
+	Kind string   // KindEmbed or KindLinkname
+	Args []string // Embed patterns, or the local and target names of a linkname
+}
+
+// Analyzer parses Go files one at a time
+type Analyzer struct {
+	fs afero.Fs
+}
+
+// New returns an Analyzer reading files from fs
+func New(fs afero.Fs) *Analyzer {
+	return &Analyzer{fs: fs}
+}
+
+// AnalyzeFile returns the directives in the Go file at path
+func (a *Analyzer) AnalyzeFile(path string) ([]Reference, error) {
+	fset := token.NewFileSet()
+	file, err := parseFile(a.fs, fset, path, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	return directives(fset, file, path)
+}
+
+// EmbedAnalyzer is a go/analysis analyzer that collects the //go:embed and
+// //go:linkname directives of a package. Its result is a []Reference sorted by
+// file and line. It needs neither type information nor other analyzers, so it
+// also runs on packages with type errors.
+var EmbedAnalyzer = &analysis.Analyzer{
+	Name:             "embedrefs",
+	Doc:              "collect the //go:embed and //go:linkname directives of a package",
+	Run:              runEmbedAnalyzer,
+	RunDespiteErrors: true,
+	ResultType:       reflect.TypeOf([]Reference(nil)),
+}
+
+func runEmbedAnalyzer(pass *analysis.Pass) (any, error) {
+	var refs []Reference
+	for _, file := range pass.Files {
+		fileRefs, err := directives(pass.Fset, file, pass.Fset.File(file.Pos()).Name())
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, fileRefs...)
+	}
+	sortReferences(refs)
+	return refs, nil
+}
+
+// MultiEmbedAnalyzer loads a set of packages once and runs EmbedAnalyzer over
+// all of them in a single go/analysis checker run
+type MultiEmbedAnalyzer struct {
+	dir string
+}
+
+// NewMultiEmbedAnalyzer returns a MultiEmbedAnalyzer loading packages from the
+// module in dir
+func NewMultiEmbedAnalyzer(dir string) *MultiEmbedAnalyzer {
+	return &MultiEmbedAnalyzer{dir: dir}
+}
+
+// Analyze returns the directives in the Go files of the packages matching
+// patterns, such as the import paths of the kept packages, sorted by file and
+// line. Test files are not analyzed.
+func (m *MultiEmbedAnalyzer) Analyze(patterns ...string) ([]Reference, error) {
+	cfg := &packages.Config{
+		Mode: packages.LoadAllSyntax,
+		Dir:  m.dir,
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Type errors do not matter to EmbedAnalyzer, but a package that could
+	// not be listed or parsed would silently lose its directives
+	var errs []error
+	for _, pkg := range pkgs {
+		for _, pkgErr := range pkg.Errors {
+			if pkgErr.Kind != packages.TypeError {
+				errs = append(errs, pkgErr)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	graph, err := checker.Analyze([]*analysis.Analyzer{EmbedAnalyzer}, pkgs, nil)
+	if err != nil {
+		return nil, err
+	}
+	var refs []Reference
+	for _, act := range graph.Roots {
+		if act.Err != nil {
+			return nil, fmt.Errorf("%s: %v", act.Package.PkgPath, act.Err)
+		}
+		refs = append(refs, act.Result.([]Reference)...)
+	}
+	sortReferences(refs)
+	return refs, nil
+}
+
+// sortReferences sorts refs by file and line
+func sortReferences(refs []Reference) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].File != refs[j].File {
+			return refs[i].File < refs[j].File
+		}
+		return refs[i].Line < refs[j].Line
+	})
+}
+
+func parseFile(fs afero.Fs, fset *token.FileSet, path string, mode parser.Mode) (*ast.File, error) {
+	src, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseFile(fset, path, src, mode)
+}
+
+// directives extracts the //go:embed and //go:linkname directives of file
+func directives(fset *token.FileSet, file *ast.File, path string) ([]Reference, error) {
+	var refs []Reference
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			var (
+				kind string
+				args []string
+			)
+			if rest, ok := directiveArgs(c.Text, "//go:embed"); ok {
+				var err error
+				if args, err = embedPatterns(rest); err != nil {
+					return nil, fmt.Errorf("%s: %v", fset.Position(c.Pos()), err)
+				}
+				kind = KindEmbed
+			} else if rest, ok := directiveArgs(c.Text, "//go:linkname"); ok {
+				kind, args = KindLinkname, strings.Fields(rest)
+			} else {
+				continue
+			}
+
+			refs = append(refs, Reference{
+				File: path,
+				Line: fset.Position(c.Pos()).Line,
+				Kind: kind,
+				Args: args,
+			})
+		}
+	}
+	return refs, nil
+}
+
+// directiveArgs returns what follows directive in the comment text, if the
+// comment is that directive followed by a space or a tab
+func directiveArgs(text, directive string) (string, bool) {
+	rest, ok := strings.CutPrefix(text, directive)
+	if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+		return "", false
+	}
+	return rest, true
+}
+
+// embedPatterns splits the arguments of a //go:embed directive into patterns
+// the way go/build does: patterns are separated by spaces and may be
+// double-quoted or back-quoted to contain spaces.
+func embedPatterns(args string) ([]string, error) {
+	var patterns []string
+	for {
+		args = strings.TrimLeftFunc(args, unicode.IsSpace)
+		if args == "" {
+			return patterns, nil
+		}
+
+		var pattern string
+	Switch:
+		switch args[0] {
+		default:
+			i := strings.IndexFunc(args, unicode.IsSpace)
+			if i < 0 {
+				i = len(args)
+			}
+			pattern, args = args[:i], args[i:]
+
+		case '`':
+			i := strings.Index(args[1:], "`")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+			pattern, args = args[1:1+i], args[1+i+1:]
+
+		case '"':
+			for i := 1; i < len(args); i++ {
+				if args[i] == '\\' {
+					i++
+					continue
+				}
+				if args[i] == '"' {
+					q, err := strconv.Unquote(args[:i+1])
+					if err != nil {
+						return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args[:i+1])
+					}
+					pattern, args = q, args[i+1:]
+					break Switch
+				}
+			}
+			return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+		}
+
+		if args != "" {
+			if r, _ := utf8.DecodeRuneInString(args); !unicode.IsSpace(r) {
+				return nil, fmt.Errorf("invalid quoted string in //go:embed: %s", args)
+			}
+		}
+		patterns = append(patterns, pattern)
+	}
+}
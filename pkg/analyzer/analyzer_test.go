@@ -0,0 +1,185 @@
+package analyzer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+const embedSrc = `package assets
+
+import (
+	"embed"
+	_ "unsafe"
+)
+
+//go:embed static/*.css "templates/index.html"
+var files embed.FS
+
+//go:linkname now time.now
+func now() (int64, int32, int64)
+`
+
+func TestAnalyzer_AnalyzeFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/assets/assets.go", []byte(embedSrc), 0644))
+
+	refs, err := New(fs).AnalyzeFile("/src/assets/assets.go")
+	require.NoError(t, err)
+	assert.Equal(t, []Reference{
+		{File: "/src/assets/assets.go", Line: 8, Kind: KindEmbed, Args: []string{"static/*.css", "templates/index.html"}},
+		{File: "/src/assets/assets.go", Line: 11, Kind: KindLinkname, Args: []string{"now", "time.now"}},
+	}, refs)
+
+	_, err = New(fs).AnalyzeFile("/src/missing.go")
+	assert.Error(t, err)
+}
+
+func TestAnalyzer_AnalyzeFileQuotedPatterns(t *testing.T) {
+	src := "package assets\n\n" +
+		"import \"embed\"\n\n" +
+		"//go:embed \"my file.txt\" `other file.txt` plain.txt\n" +
+		"var a embed.FS\n\n" +
+		"//go:embed\tstatic/*.css\n" +
+		"var b embed.FS\n\n" +
+		"//go:embedded not a directive\n" +
+		"var c embed.FS\n"
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/assets.go", []byte(src), 0644))
+
+	refs, err := New(fs).AnalyzeFile("/src/assets.go")
+	require.NoError(t, err)
+	assert.Equal(t, []Reference{
+		{File: "/src/assets.go", Line: 5, Kind: KindEmbed, Args: []string{"my file.txt", "other file.txt", "plain.txt"}},
+		{File: "/src/assets.go", Line: 8, Kind: KindEmbed, Args: []string{"static/*.css"}},
+	}, refs)
+
+	for _, directive := range []string{
+		`//go:embed "unterminated`,
+		"//go:embed `unterminated",
+		`//go:embed "a"b`,
+	} {
+		src := "package assets\n\n" + directive + "\nvar a string\n"
+		require.NoError(t, afero.WriteFile(fs, "/src/bad.go", []byte(src), 0644))
+		_, err := New(fs).AnalyzeFile("/src/bad.go")
+		assert.ErrorContains(t, err, "invalid quoted string", directive)
+	}
+}
+
+// writeModule writes files, keyed by slash-separated path, to a new module
+// example.com/m in a temporary directory and returns the directory
+func writeModule(tb testing.TB, files map[string]string) string {
+	dir := tb.TempDir()
+	files["go.mod"] = "module example.com/m\n\ngo 1.22\n"
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(tb, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(tb, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestMultiEmbedAnalyzer_Analyze(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a/plain.go":             "package assets\n\nfunc A() {}\n",
+		"a/assets.go":            embedSrc,
+		"a/assets_test.go":       "package assets_test\n\n//go:embed testdata\nvar _ string\n",
+		"a/static/site.css":      "",
+		"a/templates/index.html": "",
+		"b/assets.go":            embedSrc,
+		"b/static/site.css":      "",
+		"b/templates/index.html": "",
+	})
+
+	refs, err := NewMultiEmbedAnalyzer(dir).Analyze("./...")
+	require.NoError(t, err)
+	assets := func(pkg string) string {
+		path, err := filepath.EvalSymlinks(filepath.Join(dir, pkg, "assets.go"))
+		require.NoError(t, err)
+		return path
+	}
+	for i := range refs {
+		path, err := filepath.EvalSymlinks(refs[i].File)
+		require.NoError(t, err)
+		refs[i].File = path
+	}
+	assert.Equal(t, []Reference{
+		{File: assets("a"), Line: 8, Kind: KindEmbed, Args: []string{"static/*.css", "templates/index.html"}},
+		{File: assets("a"), Line: 11, Kind: KindLinkname, Args: []string{"now", "time.now"}},
+		{File: assets("b"), Line: 8, Kind: KindEmbed, Args: []string{"static/*.css", "templates/index.html"}},
+		{File: assets("b"), Line: 11, Kind: KindLinkname, Args: []string{"now", "time.now"}},
+	}, refs)
+
+	refs, err = NewMultiEmbedAnalyzer(dir).Analyze("example.com/m/b")
+	require.NoError(t, err)
+	assert.Len(t, refs, 2)
+
+	bad := writeModule(t, map[string]string{"bad/bad.go": "not go"})
+	_, err = NewMultiEmbedAnalyzer(bad).Analyze("./...")
+	assert.Error(t, err)
+}
+
+func TestEmbedAnalyzer(t *testing.T) {
+	require.NoError(t, analysis.Validate([]*analysis.Analyzer{EmbedAnalyzer}))
+
+	results := analysistest.Run(t, analysistest.TestData(), EmbedAnalyzer, "assets")
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+
+	file := filepath.Join(analysistest.TestData(), "src", "assets", "assets.go")
+	assert.Equal(t, []Reference{
+		{File: file, Line: 8, Kind: KindEmbed, Args: []string{"static/*.css", "templates/index.html"}},
+		{File: file, Line: 11, Kind: KindLinkname, Args: []string{"now", "time.now"}},
+	}, results[0].Result)
+}
+
+// benchmarkFiles writes 100 files spread over 10 packages
+func benchmarkFiles(b *testing.B) (string, []string) {
+	files := make(map[string]string)
+	var paths []string
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("pkg%d/assets%d.go", i%10, i)
+		files[name] = embedSrc
+		paths = append(paths, name)
+	}
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("pkg%d/static/site.css", i)] = ""
+		files[fmt.Sprintf("pkg%d/templates/index.html", i)] = ""
+	}
+	dir := writeModule(b, files)
+	for i, path := range paths {
+		paths[i] = filepath.Join(dir, filepath.FromSlash(path))
+	}
+	return dir, paths
+}
+
+func BenchmarkAnalyzer(b *testing.B) {
+	_, paths := benchmarkFiles(b)
+	a := New(afero.NewOsFs())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, path := range paths {
+			if _, err := a.AnalyzeFile(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkMultiEmbedAnalyzer(b *testing.B) {
+	dir, _ := benchmarkFiles(b)
+	m := NewMultiEmbedAnalyzer(dir)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Analyze("./..."); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
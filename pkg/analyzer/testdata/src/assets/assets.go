@@ -0,0 +1,12 @@
+package assets
+
+import (
+	"embed"
+	_ "unsafe"
+)
+
+//go:embed static/*.css "templates/index.html"
+var files embed.FS
+
+//go:linkname now time.now
+func now() (int64, int32, int64)
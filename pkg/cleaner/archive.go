@@ -0,0 +1,131 @@
+package cleaner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// Archive formats supported by WithCompressionAfterClean
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+func isArchiveFormat(format string) bool {
+	return format == ArchiveTarGz || format == ArchiveZip
+}
+
+// archiveEntry is a regular file of the cleaned tree, named relative to its
+// root with forward slashes
+type archiveEntry struct {
+	path string
+	name string
+	info fs.FileInfo
+}
+
+// writeArchive compresses the regular files under root into c.archivePath.
+// The archive itself is left out if it is written inside root.
+func (c *Cleaner) writeArchive(root string) error {
+	target, err := filepath.Abs(c.archivePath)
+	if err != nil {
+		return err
+	}
+
+	var entries []archiveEntry
+	err = afero.Walk(c.fs, root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err == nil && abs == target {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, archiveEntry{path: path, name: filepath.ToSlash(rel), info: info})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := c.fs.Create(target)
+	if err != nil {
+		return err
+	}
+
+	if c.archiveFormat == ArchiveZip {
+		err = c.writeZip(f, entries)
+	} else {
+		err = c.writeTarGz(f, entries)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (c *Cleaner) writeTarGz(w io.Writer, entries []archiveEntry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr, err := tar.FileInfoHeader(e.info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := c.copyEntry(tw, e.path); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func (c *Cleaner) writeZip(w io.Writer, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		hdr, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		hdr.Method = zip.Deflate
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if err := c.copyEntry(fw, e.path); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func (c *Cleaner) copyEntry(w io.Writer, path string) error {
+	f, err := c.fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,135 @@
+package cleaner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func archiveTestFs(t *testing.T) afero.Fs {
+	memFs := afero.NewMemMapFs()
+	for path, content := range map[string]string{
+		"/src/go.mod":            "module example.com/src",
+		"/src/pkg1/file1.go":     "package pkg1",
+		"/src/pkg1/sub/file2.go": "package sub",
+		"/src/pkg2/file3.go":     "package pkg2",
+	} {
+		require.NoError(t, afero.WriteFile(memFs, path, []byte(content), 0644))
+	}
+	return memFs
+}
+
+func readTarGz(t *testing.T, memFs afero.Fs, path string) map[string]string {
+	f, err := memFs.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+
+	contents := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		contents[hdr.Name] = string(data)
+	}
+	return contents
+}
+
+func readZip(t *testing.T, memFs afero.Fs, path string) map[string]string {
+	data, err := afero.ReadFile(memFs, path)
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	contents := make(map[string]string)
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		contents[zf.Name] = string(data)
+	}
+	return contents
+}
+
+func TestCleaner_CompressionAfterClean(t *testing.T) {
+	want := map[string]string{
+		"go.mod":        "module example.com/src",
+		"pkg1/file1.go": "package pkg1",
+	}
+	keep := []string{"/src/go.mod", "/src/pkg1/file1.go"}
+
+	tests := []struct {
+		format string
+		read   func(*testing.T, afero.Fs, string) map[string]string
+	}{
+		{format: ArchiveTarGz, read: readTarGz},
+		{format: ArchiveZip, read: readZip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			memFs := archiveTestFs(t)
+			out := "/out/src." + tt.format
+			c := NewWithFs("/src", keep, memFs, WithCompressionAfterClean(tt.format, out))
+			_, err := c.Clean()
+			require.NoError(t, err)
+			assert.Equal(t, want, tt.read(t, memFs, out))
+		})
+	}
+}
+
+func TestCleaner_CompressionAfterCleanInsideSource(t *testing.T) {
+	memFs := archiveTestFs(t)
+	out := "/src/src.tar.gz"
+	c := NewWithFs("/src", []string{"/src/go.mod", "/src/pkg2/file3.go", out}, memFs,
+		WithCompressionAfterClean(ArchiveTarGz, out))
+	_, err := c.Clean()
+	require.NoError(t, err)
+
+	var names []string
+	for name := range readTarGz(t, memFs, out) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"go.mod", "pkg2/file3.go"}, names)
+}
+
+func TestCleaner_CompressionAfterCleanDryRun(t *testing.T) {
+	memFs := archiveTestFs(t)
+	c := NewWithFs("/src", nil, memFs, WithAllowEmptyKeepList(true), WithDryRun(true), WithCompressionAfterClean(ArchiveZip, "/out/src.zip"))
+	_, err := c.Clean()
+	require.NoError(t, err)
+
+	exists, err := afero.Exists(memFs, "/out/src.zip")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCleaner_CompressionAfterCleanUnsupportedFormat(t *testing.T) {
+	memFs := archiveTestFs(t)
+	c := NewWithFs("/src", nil, memFs, WithAllowEmptyKeepList(true), WithCompressionAfterClean("rar", "/out/src.rar"))
+	_, err := c.Clean()
+	assert.ErrorContains(t, err, `unsupported archive format "rar"`)
+
+	// Nothing is removed
+	exists, err := afero.Exists(memFs, "/src/pkg2/file3.go")
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
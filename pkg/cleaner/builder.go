@@ -0,0 +1,69 @@
+package cleaner
+
+import (
+	"errors"
+
+	"github.com/spf13/afero"
+)
+
+// CleanerBuilder is a fluent alternative to New for constructing a Cleaner
+type CleanerBuilder struct {
+	sourceDir string
+	keep      []string
+	fs        afero.Fs
+	opts      []Option
+}
+
+// NewBuilder returns an empty CleanerBuilder
+func NewBuilder() *CleanerBuilder {
+	return &CleanerBuilder{}
+}
+
+// Dir sets the source directory to clean. It is required.
+func (b *CleanerBuilder) Dir(s string) *CleanerBuilder {
+	b.sourceDir = s
+	return b
+}
+
+// Keep adds files to the keep list
+func (b *CleanerBuilder) Keep(files ...string) *CleanerBuilder {
+	b.keep = append(b.keep, files...)
+	return b
+}
+
+// DryRun enables dry-run mode
+func (b *CleanerBuilder) DryRun() *CleanerBuilder {
+	b.opts = append(b.opts, WithDryRun(true))
+	return b
+}
+
+// ProtectGit enables .git directory protection. Protection is already enabled
+// by default; this makes the intent explicit.
+func (b *CleanerBuilder) ProtectGit() *CleanerBuilder {
+	b.opts = append(b.opts, WithGitProtection(true))
+	return b
+}
+
+// Fs sets the filesystem the Cleaner operates on
+func (b *CleanerBuilder) Fs(fs afero.Fs) *CleanerBuilder {
+	b.fs = fs
+	return b
+}
+
+// With adds options not covered by the builder methods
+func (b *CleanerBuilder) With(opts ...Option) *CleanerBuilder {
+	b.opts = append(b.opts, opts...)
+	return b
+}
+
+// Build validates the configuration and returns the Cleaner
+func (b *CleanerBuilder) Build() (*Cleaner, error) {
+	if b.sourceDir == "" {
+		return nil, errors.New("source directory is required")
+	}
+
+	if b.fs != nil {
+		return NewWithFs(b.sourceDir, b.keep, b.fs, b.opts...), nil
+	}
+	return New(b.sourceDir, b.keep, b.opts...), nil
+}
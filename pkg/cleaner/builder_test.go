@@ -0,0 +1,60 @@
+package cleaner
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanerBuilder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, file := range []string{"/src/keep.go", "/src/other.go", "/src/remove.go", "/src/.git/config"} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+	}
+
+	c, err := NewBuilder().
+		Dir("/src").
+		Keep("/src/keep.go").
+		Keep("/src/other.go").
+		ProtectGit().
+		Fs(fs).
+		With(WithGoModTidy(false)).
+		Build()
+	require.NoError(t, err)
+	_, err = c.Clean()
+	require.NoError(t, err)
+
+	for file, want := range map[string]bool{
+		"/src/keep.go":     true,
+		"/src/other.go":    true,
+		"/src/remove.go":   false,
+		"/src/.git/config": true,
+	} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, file)
+	}
+}
+
+func TestCleanerBuilder_DryRun(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("test content"), 0644))
+
+	c, err := NewBuilder().Dir("/src").DryRun().Fs(fs).With(WithAllowEmptyKeepList(true)).Build()
+	require.NoError(t, err)
+	_, err = c.Clean()
+	require.NoError(t, err)
+
+	exists, err := afero.Exists(fs, "/src/remove.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, []string{"/src/remove.go"}, c.Report().Removed)
+}
+
+func TestCleanerBuilder_MissingDir(t *testing.T) {
+	c, err := NewBuilder().Keep("/src/keep.go").Build()
+	assert.Error(t, err)
+	assert.Nil(t, c)
+}
@@ -1,27 +1,141 @@
 package cleaner
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"log"
 
+	"github.com/sigma/monorepo-hatchet/pkg/pkglist"
+	"github.com/sigma/monorepo-hatchet/pkg/report"
+	"github.com/sigma/monorepo-hatchet/pkg/walker"
 	"github.com/spf13/afero"
 )
 
+// CleaningMode controls how strictly the keep list is honoured
+type CleaningMode int
+
+const (
+	// ModeConservative keeps every file in the keep list. This is the default.
+	ModeConservative CleaningMode = iota
+	// ModeAggressive additionally removes *_test.go files from the keep list
+	// unless test keeping is enabled.
+	ModeAggressive
+)
+
+// FsType selects the filesystem a Cleaner operates on
+type FsType int
+
+const (
+	// FsTypeOS operates on the operating system filesystem. This is the default.
+	FsTypeOS FsType = iota
+	// FsTypeMemory operates on an empty in-memory filesystem.
+	FsTypeMemory
+	// FsTypeReadOnly operates on a read-only view of the operating system
+	// filesystem.
+	FsTypeReadOnly
+)
+
 type Cleaner struct {
 	sourceDir      string
 	filesToKeep    map[string]struct{}
 	fs             afero.Fs
 	protectGit     bool
 	protectGoMod   bool
+	protectWork    bool
 	keepTests      bool
 	dryRun         bool
 	runGoModTidy   bool
 	protectedPaths []string
+	report         *report.Report
+	progress       func(done, total int)
+	walker         walker.Walker
+	dryRunFile     string
+	stdout         io.Writer // where a report file of "-" is written
+	mode           CleaningMode
+	removedPkgs    map[string]string
+	preserveDirs   bool
+	outputDir      string
+	trashDir       string
+	followSymlinks bool
+	touchKept      bool
+	errorHandler   func(path string, err error)
+	checkOwner     bool
+	mirror         bool
+	runVet         bool
+	keepExcluded   bool
+	manifest       bool
+	hardLinkDedup  bool
+	keepRecent     time.Duration
+	warnNewFiles   bool
+	gitAdd         bool
+	moduleDirs     []string
+	concurrency    int
+	preserveTypes  []string
+	buildVerify    bool
+	checksumFile   string
+	runStaticcheck bool
+	archiveFormat  string
+	archivePath    string
+	allowEmptyKeep bool
+	goModReplaces  []goModReplace
+	keepTestBins   bool
+	diffTree       io.Writer
+	dryRunCallback func(*report.Report)
+	protectFuncs   []func(absPath string) bool
+	rollback       bool
+	commander      pkglist.Commander
+	logger         pkglist.Logger
+}
+
+// ProtectedPathsEnv is the environment variable read for protected paths when
+// none are set with WithProtectedPaths
+const ProtectedPathsEnv = "HATCHET_PROTECTED_PATHS"
+
+// ErrReadOnlyFilesystem is returned when the source directory cannot be written to
+var ErrReadOnlyFilesystem = errors.New("source directory is on a read-only filesystem")
+
+// ErrEmptyKeepList is returned by Clean when the keep list is empty, which
+// would remove every unprotected file, unless WithAllowEmptyKeepList is set
+var ErrEmptyKeepList = errors.New("keep list is empty")
+
+// VetError is returned when go vet reports problems in the cleaned tree
+type VetError struct {
+	Output string
+}
+
+func (e *VetError) Error() string {
+	return fmt.Sprintf("go vet failed:\n%s", e.Output)
+}
+
+// StaticcheckError is returned when staticcheck reports problems in the
+// cleaned tree
+type StaticcheckError struct {
+	Output string
+}
+
+func (e *StaticcheckError) Error() string {
+	return fmt.Sprintf("staticcheck failed:\n%s", e.Output)
+}
+
+// CleanSummary describes the outcome of a Clean. In dry-run mode it describes
+// what the clean would have done.
+type CleanSummary struct {
+	FilesRemoved int   // Files removed, or left out of the output directory
+	BytesFreed   int64 // Total size of the removed files
+	DirsRemoved  int   // Directories removed because the clean left them empty
+	FilesKept    int   // Files kept
 }
 
 type Option func(*Cleaner)
@@ -40,6 +154,14 @@ func WithGoModProtection(protect bool) Option {
 	}
 }
 
+// WithWorkspaceProtection enables or disables go.work and go.work.sum
+// protection
+func WithWorkspaceProtection(protect bool) Option {
+	return func(c *Cleaner) {
+		c.protectWork = protect
+	}
+}
+
 // WithDryRun enables or disables dry-run mode (no files will be removed)
 func WithDryRun(dryRun bool) Option {
 	return func(c *Cleaner) {
@@ -61,12 +183,316 @@ func WithGoModTidy(enabled bool) Option {
 	}
 }
 
+// WithProtectedPaths protects files and directories (relative to the source
+// directory) from being cleaned. When no paths are given, they are read from
+// the colon-separated HATCHET_PROTECTED_PATHS environment variable.
 func WithProtectedPaths(paths []string) Option {
 	return func(c *Cleaner) {
 		c.protectedPaths = paths
 	}
 }
 
+// WithProgressCallback registers fn to be called as files are removed. It is
+// called once with (0, total) before the first removal and then after each one.
+func WithProgressCallback(fn func(done, total int)) Option {
+	return func(c *Cleaner) {
+		c.progress = fn
+	}
+}
+
+// WithWalker sets the walker used to traverse the source directory. By default
+// the source directory is walked sequentially, with filepath.WalkDir on the OS
+// filesystem.
+func WithWalker(w walker.Walker) Option {
+	return func(c *Cleaner) {
+		c.walker = w
+	}
+}
+
+// WithDryRunFile writes the JSON report of the run to path, or to standard
+// output if path is "-". The report is written in both dry-run and regular
+// mode.
+func WithDryRunFile(path string) Option {
+	return func(c *Cleaner) {
+		c.dryRunFile = path
+	}
+}
+
+// WithDryRunCallback calls fn with the report of every dry-run Clean once it
+// completes, in addition to any report file written with WithDryRunFile
+func WithDryRunCallback(fn func(*report.Report)) Option {
+	return func(c *Cleaner) {
+		c.dryRunCallback = fn
+	}
+}
+
+// WithCleaning sets the cleaning mode (ModeConservative by default)
+func WithCleaning(mode CleaningMode) Option {
+	return func(c *Cleaner) {
+		c.mode = mode
+	}
+}
+
+// WithRemovedPackages records the packages excluded by negation patterns in
+// the report
+func WithRemovedPackages(removed map[string]string) Option {
+	return func(c *Cleaner) {
+		c.removedPkgs = removed
+	}
+}
+
+// WithPreserveDirectoryStructure enables or disables keeping directories that
+// are left empty after cleaning
+func WithPreserveDirectoryStructure(preserve bool) Option {
+	return func(c *Cleaner) {
+		c.preserveDirs = preserve
+	}
+}
+
+// WithOutputDir copies the kept files to dir instead of removing anything from
+// the source directory
+func WithOutputDir(dir string) Option {
+	return func(c *Cleaner) {
+		c.outputDir = dir
+	}
+}
+
+// WithErrorHandler calls fn with each file that could not be removed instead
+// of returning the error from Clean. fn is never called concurrently.
+func WithErrorHandler(fn func(path string, err error)) Option {
+	return func(c *Cleaner) {
+		c.errorHandler = fn
+	}
+}
+
+// WithTrashDir moves the files that would be removed under dir instead of
+// deleting them, keeping their absolute path, so that /src/pkg/foo.go ends up
+// at dir/src/pkg/foo.go. Files are moved with Rename, so dir should be on the
+// same filesystem as the source directory.
+func WithTrashDir(dir string) Option {
+	return func(c *Cleaner) {
+		c.trashDir = dir
+	}
+}
+
+// WithMirrorMode enables or disables mirroring the source directory structure
+// exactly when copying to the output directory, including empty directories
+// and file permissions
+func WithMirrorMode(mirror bool) Option {
+	return func(c *Cleaner) {
+		c.mirror = mirror
+	}
+}
+
+// WithRunVet enables or disables running go vet ./... after cleaning
+func WithRunVet(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.runVet = enabled
+	}
+}
+
+// WithRunStaticcheck enables or disables running staticcheck ./... after
+// cleaning. It is skipped with a warning if staticcheck is not installed.
+func WithRunStaticcheck(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.runStaticcheck = enabled
+	}
+}
+
+// WithCommander sets the commander used to run external commands
+func WithCommander(cmd pkglist.Commander) Option {
+	return func(c *Cleaner) {
+		c.commander = cmd
+	}
+}
+
+// WithLogger sets the logger progress messages and warnings are written to.
+// By default they go to the standard logger; use pkglist.NopLogger to discard
+// them.
+func WithLogger(l pkglist.Logger) Option {
+	return func(c *Cleaner) {
+		c.logger = l
+	}
+}
+
+// logf writes a message to the configured logger, or to the standard logger
+// when none is set
+func (c *Cleaner) logf(format string, v ...any) {
+	if c.logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	c.logger.Printf(format, v...)
+}
+
+// WithPreserveBuildConstraintFiles enables or disables keeping Go files that
+// the current build context excludes through build constraints, as they may be
+// needed for other build configurations
+func WithPreserveBuildConstraintFiles(preserve bool) Option {
+	return func(c *Cleaner) {
+		c.keepExcluded = preserve
+	}
+}
+
+// WithManifest enables or disables writing a MANIFEST.txt listing the kept
+// files with their SHA-256 hashes and sizes after cleaning
+func WithManifest(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.manifest = enabled
+	}
+}
+
+// WithHardLinkDedup enables or disables replacing identical files copied to the
+// output directory with hard links
+func WithHardLinkDedup(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.hardLinkDedup = enabled
+	}
+}
+
+// WithKeepRecentlyModified always keeps files modified within d of the start
+// of the clean, protecting work in progress
+func WithKeepRecentlyModified(d time.Duration) Option {
+	return func(c *Cleaner) {
+		c.keepRecent = d
+	}
+}
+
+// WithGitNewFileWarning enables or disables warning about removed files that
+// were modified after the last git commit
+func WithGitNewFileWarning(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.warnNewFiles = enabled
+	}
+}
+
+// WithGitAdd enables or disables staging each removed file in the git index
+func WithGitAdd(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.gitAdd = enabled
+	}
+}
+
+// WithModuleDirs sets the module directories (inside the source directory) in
+// which go mod tidy is run. By default it is run in the source directory.
+func WithModuleDirs(dirs []string) Option {
+	return func(c *Cleaner) {
+		c.moduleDirs = dirs
+	}
+}
+
+// WithConcurrency removes files using a pool of n workers, which helps on
+// networked, FUSE or object-store backed filesystems. Files are removed
+// sequentially by default.
+func WithConcurrency(n int) Option {
+	return func(c *Cleaner) {
+		c.concurrency = n
+	}
+}
+
+// WithParallelRemove removes files using a pool of n workers.
+//
+// Deprecated: use WithConcurrency.
+func WithParallelRemove(n int) Option {
+	return WithConcurrency(n)
+}
+
+// WithPreserveByContentType keeps files whose sniffed MIME type (e.g.
+// "image/png") is one of types, regardless of the keep list
+func WithPreserveByContentType(types []string) Option {
+	return func(c *Cleaner) {
+		c.preserveTypes = types
+	}
+}
+
+// WithBuildVerify enables or disables checking that the source directory
+// still builds without the files to remove before removing them. If it does
+// not, Clean returns a *BuildVerifyError and removes nothing.
+func WithBuildVerify(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.buildVerify = enabled
+	}
+}
+
+// WithChecksumVerify checks every file in the keep list against the manifest
+// at manifestPath, as written by WithManifest, before cleaning. If a file does
+// not match, Clean returns a *ChecksumMismatchError and changes nothing.
+func WithChecksumVerify(manifestPath string) Option {
+	return func(c *Cleaner) {
+		c.checksumFile = manifestPath
+	}
+}
+
+// WithCompressionAfterClean writes the cleaned tree to an archive at
+// outputPath once the clean is done. format is "tar.gz" or "zip". Nothing is
+// written in dry-run mode.
+func WithCompressionAfterClean(format string, outputPath string) Option {
+	return func(c *Cleaner) {
+		c.archiveFormat = format
+		c.archivePath = outputPath
+	}
+}
+
+// WithAllowEmptyKeepList allows Clean to run with an empty keep list instead
+// of returning ErrEmptyKeepList
+func WithAllowEmptyKeepList(allow bool) Option {
+	return func(c *Cleaner) {
+		c.allowEmptyKeep = allow
+	}
+}
+
+// WithGoModReplace points the replace directive for oldMod in the go.mod of
+// the cleaned tree at newPath once the clean is done, so that replacements
+// whose target was removed can be redirected. newPath may be relative to the
+// go.mod directory and must exist. Each call adds a replacement.
+func WithGoModReplace(oldMod, newPath string) Option {
+	return func(c *Cleaner) {
+		c.goModReplaces = append(c.goModReplaces, goModReplace{oldMod: oldMod, newPath: newPath})
+	}
+}
+
+// WithPreserveTestBinaries enables or disables keeping compiled test binaries,
+// as built by go test -c: files named *.test, and on Unix files named *_test
+// without an extension
+func WithPreserveTestBinaries(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.keepTestBins = enabled
+	}
+}
+
+// WithDiffTree writes a unified diff of the source directory tree before and
+// after the clean to w. In dry-run mode the tree after is predicted, and when
+// copying to an output directory the tree after is that of the output.
+func WithDiffTree(w io.Writer) Option {
+	return func(c *Cleaner) {
+		c.diffTree = w
+	}
+}
+
+// WithCustomProtectionFunc protects every file for which fn returns true. It is
+// called with the absolute path of each file walked. Each call adds a function,
+// and a file is protected if any of them returns true.
+func WithCustomProtectionFunc(fn func(absPath string) bool) Option {
+	return func(c *Cleaner) {
+		c.protectFuncs = append(c.protectFuncs, fn)
+	}
+}
+
+// WithFsType sets the filesystem the Cleaner operates on (FsTypeOS by
+// default). Use NewWithFs for a custom filesystem.
+func WithFsType(t FsType) Option {
+	return func(c *Cleaner) {
+		switch t {
+		case FsTypeMemory:
+			c.fs = afero.NewMemMapFs()
+		case FsTypeReadOnly:
+			c.fs = afero.NewReadOnlyFs(afero.NewOsFs())
+		default:
+			c.fs = afero.NewOsFs()
+		}
+	}
+}
+
 func New(sourceDir string, filesToKeep []string, opts ...Option) *Cleaner {
 	keepFiles := make(map[string]struct{})
 	for _, file := range filesToKeep {
@@ -79,16 +505,38 @@ func New(sourceDir string, filesToKeep []string, opts ...Option) *Cleaner {
 		fs:           afero.NewOsFs(),
 		protectGit:   true,  // protect .git by default
 		protectGoMod: true,  // protect go.mod and go.sum by default
+		protectWork:  true,  // protect go.work and go.work.sum by default
 		keepTests:    false, // don't keep tests by default
+		commander:    &pkglist.RealCommander{},
+		stdout:       os.Stdout,
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
 
+	if len(c.protectedPaths) == 0 {
+		c.protectedPaths = protectedPathsFromEnv()
+	}
+
 	return c
 }
 
+func protectedPathsFromEnv() []string {
+	env := os.Getenv(ProtectedPathsEnv)
+	if env == "" {
+		return nil
+	}
+
+	var paths []string
+	for _, p := range strings.Split(env, ":") {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, filepath.Clean(p))
+		}
+	}
+	return paths
+}
+
 // NewWithFs creates a new Cleaner with a custom filesystem - useful for testing
 func NewWithFs(sourceDir string, filesToKeep []string, fs afero.Fs, opts ...Option) *Cleaner {
 	c := New(sourceDir, filesToKeep, opts...)
@@ -96,129 +544,648 @@ func NewWithFs(sourceDir string, filesToKeep []string, fs afero.Fs, opts ...Opti
 	return c
 }
 
-func (c *Cleaner) Clean() error {
+// Report returns the report of the last Clean run, or nil if Clean has not run
+func (c *Cleaner) Report() *report.Report {
+	return c.report
+}
+
+// Clean removes the files not kept from the source directory, or copies the
+// kept files to the output directory
+func (c *Cleaner) Clean() (CleanSummary, error) {
+	return c.CleanContext(context.Background())
+}
+
+// CleanContext is like Clean but stops and returns ctx.Err() when ctx is done
+func (c *Cleaner) CleanContext(ctx context.Context) (CleanSummary, error) {
+	start := time.Now()
+	rep := &report.Report{DryRun: c.dryRun, RemovedPackages: c.removedPkgs}
+	c.report = rep
+	var summary CleanSummary
+
+	if len(c.filesToKeep) == 0 && !c.allowEmptyKeep {
+		return summary, ErrEmptyKeepList
+	}
+
+	if c.archivePath != "" && !isArchiveFormat(c.archiveFormat) {
+		return summary, fmt.Errorf("unsupported archive format %q", c.archiveFormat)
+	}
+
+	if c.checksumFile != "" {
+		if err := c.verifyChecksums(c.checksumFile); err != nil {
+			return summary, err
+		}
+	}
+
+	// Fail early rather than on every removal if the source is not writable
+	if !c.dryRun && c.outputDir == "" {
+		if err := c.checkWritable(); err != nil {
+			return summary, err
+		}
+	}
+
+	var treeBefore []string
+	if c.diffTree != nil {
+		var err error
+		if treeBefore, err = c.listTree(c.sourceDir); err != nil {
+			return summary, fmt.Errorf("failed to list %s: %v", c.sourceDir, err)
+		}
+	}
+
 	// First pass: collect all files to remove
-	var toRemove []string
-	err := afero.Walk(c.fs, c.sourceDir, func(path string, info fs.FileInfo, err error) error {
+	toRemove, dirs, errs, err := c.scan(ctx, start, rep)
+	if err != nil {
+		return summary, err
+	}
+	rep.Removed = toRemove
+	summary.FilesKept = len(rep.Kept)
+	summary.FilesRemoved = len(toRemove)
+	summary.BytesFreed = rep.BytesReclaimed
+
+	resultDir := c.sourceDir
+	if c.outputDir != "" {
+		resultDir = c.outputDir
+
+		// Copy kept files instead of removing anything
+		if !c.dryRun {
+			if err := c.copyToOutput(rep.Kept, dirs); err != nil {
+				return summary, fmt.Errorf("failed to copy to %s: %v", c.outputDir, err)
+			}
+			if c.hardLinkDedup {
+				if err := c.dedupHardLinks(rep.Kept); err != nil {
+					return summary, fmt.Errorf("failed to deduplicate %s: %v", c.outputDir, err)
+				}
+			}
+		}
+	} else {
+		if c.warnNewFiles {
+			c.warnNewerThanCommit(toRemove)
+		}
+
+		if c.buildVerify {
+			if err := c.verifyBuild(ctx, toRemove); err != nil {
+				return summary, err
+			}
+		}
+
+		if c.rollback && !c.dryRun {
+			if err := c.writeRollback(toRemove); err != nil {
+				return summary, fmt.Errorf("failed to write %s: %v", RollbackFileName, err)
+			}
+		}
+
+		// Second pass: remove files
+		removedCount, err := c.removeFiles(ctx, toRemove)
+		summary.FilesRemoved = removedCount
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return summary, ctxErr
+		}
 		if err != nil {
-			return err
+			errs = append(errs, err)
+		}
+
+		// Third pass: remove empty directories
+		if !c.preserveDirs {
+			removed := make(map[string]struct{}, len(toRemove))
+			for _, path := range toRemove {
+				removed[path] = struct{}{}
+			}
+			pruned, _, err := c.removeEmptyDirs(c.sourceDir, removed, c.dryRun)
+			summary.DirsRemoved = len(pruned)
+			if err != nil {
+				return summary, fmt.Errorf("failed to clean empty directories: %v", err)
+			}
+		}
+
+		if c.touchKept {
+			if err := c.touchKeptFiles(time.Now()); err != nil {
+				return summary, fmt.Errorf("failed to touch kept files: %v", err)
+			}
+		}
+	}
+
+	// Write the manifest of kept files if requested
+	if !c.dryRun && c.manifest {
+		if err := c.writeManifest(resultDir, rep.Kept); err != nil {
+			return summary, fmt.Errorf("failed to write manifest: %v", err)
+		}
+	}
+
+	// Update replace directives before tidying, which needs them to resolve
+	if !c.dryRun && len(c.goModReplaces) > 0 {
+		if err := c.updateGoModReplaces(resultDir); err != nil {
+			return summary, fmt.Errorf("failed to update go.mod replace directives: %v", err)
+		}
+	}
+
+	// Run go mod tidy after cleaning if requested
+	if !c.dryRun && c.runGoModTidy {
+		tidyDirs := []string{resultDir}
+		if len(c.moduleDirs) > 0 {
+			tidyDirs = tidyDirs[:0]
+			for _, dir := range c.moduleDirs {
+				rel, err := filepath.Rel(c.sourceDir, dir)
+				if err != nil {
+					return summary, fmt.Errorf("failed to resolve module directory %s: %v", dir, err)
+				}
+				tidyDirs = append(tidyDirs, filepath.Join(resultDir, rel))
+			}
+		}
+
+		for _, dir := range tidyDirs {
+			cmd := pkglist.CommandWithContext(ctx, c.commander, "go", "mod", "tidy")
+			cmd.SetDir(dir)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return summary, fmt.Errorf("failed to run go mod tidy in %s: %v\nOutput: %s", dir, err, out)
+			}
+			c.logf("Successfully ran go mod tidy in %s", dir)
+		}
+	}
+
+	// Run go vet to catch code broken by the clean if requested
+	if !c.dryRun && c.runVet {
+		cmd := pkglist.CommandWithContext(ctx, c.commander, "go", "vet", "./...")
+		cmd.SetDir(resultDir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return summary, &VetError{Output: string(out)}
 		}
+		c.logf("Successfully ran go vet in %s", resultDir)
+	}
+
+	// Run staticcheck for a deeper check if requested
+	if !c.dryRun && c.runStaticcheck {
+		cmd := pkglist.CommandWithContext(ctx, c.commander, "staticcheck", "./...")
+		cmd.SetDir(resultDir)
+		out, err := cmd.CombinedOutput()
+		switch {
+		case errors.Is(err, exec.ErrNotFound):
+			c.logf("Warning: staticcheck not found in PATH, skipping")
+		case err != nil:
+			return summary, &StaticcheckError{Output: string(out)}
+		default:
+			c.logf("Successfully ran staticcheck in %s", resultDir)
+		}
+	}
 
-		// Skip directories for now
+	// Compress the cleaned tree if requested
+	if c.archivePath != "" {
+		if c.dryRun {
+			c.logf("Dry run: skipping compression to %s", c.archivePath)
+		} else {
+			if err := c.writeArchive(resultDir); err != nil {
+				return summary, fmt.Errorf("failed to write archive %s: %v", c.archivePath, err)
+			}
+			c.logf("Wrote %s", c.archivePath)
+		}
+	}
+
+	if c.diffTree != nil {
+		var treeAfter []string
+		if c.dryRun {
+			treeAfter = c.predictTree(treeBefore, toRemove)
+		} else if treeAfter, err = c.listTree(resultDir); err != nil {
+			return summary, fmt.Errorf("failed to list %s: %v", resultDir, err)
+		}
+		if err := report.WriteTreeDiff(c.diffTree, treeBefore, treeAfter); err != nil {
+			return summary, fmt.Errorf("failed to write tree diff: %v", err)
+		}
+	}
+
+	rep.Duration = time.Since(start)
+
+	if c.dryRunFile != "" {
+		if err := c.writeReport(c.dryRunFile); err != nil {
+			return summary, fmt.Errorf("failed to write report to %s: %v", c.dryRunFile, err)
+		}
+	}
+
+	if c.dryRun && c.dryRunCallback != nil {
+		c.dryRunCallback(rep)
+	}
+
+	return summary, errors.Join(errs...)
+}
+
+// scan walks the source directory, adding the files to keep to rep.Kept and
+// returning the files to remove. The directories walked are returned in
+// mirror mode, and files that could not be read are returned as errs.
+func (c *Cleaner) scan(ctx context.Context, start time.Time, rep *report.Report) ([]string, []string, []error, error) {
+	skipDirs, err := c.readSkipFile()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read %s: %v", SkipFileName, err)
+	}
+	skipFile := filepath.Join(c.sourceDir, SkipFileName)
+	rollbackFile := filepath.Join(c.sourceDir, RollbackFileName)
+
+	w := c.walker
+	if w == nil {
+		w = c.defaultWalker()
+	}
+
+	var (
+		mu       sync.Mutex
+		toRemove []string
+		dirs     []string
+		errs     []error
+		visit    walker.WalkFunc
+	)
+	// Symlinked directories being walked, to stop at symlink loops
+	following := map[string]struct{}{filepath.Clean(c.sourceDir): {}}
+	visit = func(path string, info fs.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		// Record files that cannot be read and carry on with the rest
+		if err != nil {
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("failed to stat %s: %w", path, err))
+			mu.Unlock()
+			return nil
+		}
+
+		// Skip directories for now, only recording them for mirroring
 		if info.IsDir() {
+			if c.outputDir != "" && path == c.outputDir {
+				return filepath.SkipDir
+			}
+			if c.trashDir != "" && path == c.trashDir {
+				return filepath.SkipDir
+			}
+			if _, skip := skipDirs[info.Name()]; skip && path != c.sourceDir {
+				return filepath.SkipDir
+			}
+			if c.mirror {
+				mu.Lock()
+				dirs = append(dirs, path)
+				mu.Unlock()
+			}
 			return nil
 		}
 
+		// Walk symlinked directories in place if requested
+		if c.followSymlinks && isSymlink(info) {
+			if target, ok := c.symlinkedDir(path); ok {
+				mu.Lock()
+				_, loop := following[target]
+				following[target] = struct{}{}
+				mu.Unlock()
+				if !loop {
+					defer func() {
+						mu.Lock()
+						delete(following, target)
+						mu.Unlock()
+					}()
+					return w.Walk(target, func(p string, info fs.FileInfo, err error) error {
+						rel, relErr := filepath.Rel(target, p)
+						if relErr != nil {
+							return relErr
+						}
+						return visit(filepath.Join(path, rel), info, err)
+					})
+				}
+			}
+		}
+
 		// Convert to absolute path for comparison
 		absPath, err := filepath.Abs(path)
 		if err != nil {
 			return fmt.Errorf("failed to get absolute path for %s: %v", path, err)
 		}
 
-		var relPath string
-		// Keep files that are in our keep list
-		if _, keep := c.filesToKeep[absPath]; keep {
+		// The walker may visit files concurrently
+		mu.Lock()
+		defer mu.Unlock()
+
+		recent := c.keepRecent > 0 && start.Sub(info.ModTime()) < c.keepRecent
+		keepLink := isSymlink(info) && c.keepsBelow(absPath)
+		if recent || keepLink || path == skipFile || path == rollbackFile || !c.shouldRemove(absPath) {
+			rep.Kept = append(rep.Kept, absPath)
 			return nil
 		}
 
-		// Keep .git files if protection is enabled
-		if c.protectGit {
-			// Check if the path is .git or is under .git directory
-			relPath, err := filepath.Rel(c.sourceDir, absPath)
-			if err == nil && (relPath == ".git" || strings.HasPrefix(relPath, ".git"+string(filepath.Separator))) {
+		if c.checkOwner {
+			if uid, other := ownedByOther(info); other {
+				c.logf("Warning: skipping %s owned by uid %d", absPath, uid)
+				rep.Kept = append(rep.Kept, absPath)
 				return nil
 			}
 		}
 
-		// Handle testdata directories
-		inTestdata := strings.Contains(absPath, "/testdata/")
+		toRemove = append(toRemove, absPath)
+		rep.BytesReclaimed += info.Size()
+		return nil
+	}
+	err = w.Walk(c.sourceDir, visit)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, nil, ctxErr
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to walk directory: %v", err)
+	}
+	return toRemove, dirs, errs, nil
+}
 
-		// Keep go.mod and go.sum files if protection is enabled (except in testdata)
-		if c.protectGoMod && !inTestdata {
-			base := filepath.Base(absPath)
-			if base == "go.mod" || base == "go.sum" {
-				return nil
+func (c *Cleaner) writeReport(path string) error {
+	data, err := json.MarshalIndent(c.report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err := c.stdout.Write(append(data, '\n'))
+		return err
+	}
+	return afero.WriteFile(c.fs, path, append(data, '\n'), 0644)
+}
+
+// checkWritable verifies that files can be created and removed in the source directory
+func (c *Cleaner) checkWritable() error {
+	f, err := afero.TempFile(c.fs, c.sourceDir, ".hatchet-write-check-*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrReadOnlyFilesystem, err)
+	}
+	name := f.Name()
+	f.Close()
+	if err := c.fs.Remove(name); err != nil {
+		return fmt.Errorf("%w: %v", ErrReadOnlyFilesystem, err)
+	}
+	return nil
+}
+
+func (c *Cleaner) reportProgress(done, total int) {
+	if c.progress != nil {
+		c.progress(done, total)
+	}
+}
+
+// defaultWalker returns a walker for c.fs, using filepath.WalkDir on the OS
+// filesystem
+func (c *Cleaner) defaultWalker() walker.Walker {
+	if _, ok := c.fs.(*afero.OsFs); ok {
+		return walker.NewDir()
+	}
+	return walker.New(c.fs)
+}
+
+// removeFiles removes files, using up to c.concurrency workers, reporting
+// progress after each removal. A failed removal does not stop the others; the
+// number of files removed is returned with all the errors joined, unless they
+// were passed to the error handler. No more files are removed once ctx is done.
+func (c *Cleaner) removeFiles(ctx context.Context, files []string) (int, error) {
+	var (
+		mu        sync.Mutex
+		handlerMu sync.Mutex // serialises calls to the error handler
+		gitMu     sync.Mutex // git cannot update the index concurrently
+		wg        sync.WaitGroup
+		done      int
+		errs      []error
+	)
+	c.reportProgress(0, len(files))
+
+	paths := make(chan string)
+	for i := 0; i < max(c.concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if !c.dryRun {
+					if err := c.removeFile(path); err != nil {
+						if c.errorHandler != nil {
+							handlerMu.Lock()
+							c.errorHandler(path, err)
+							handlerMu.Unlock()
+						} else {
+							mu.Lock()
+							errs = append(errs, fmt.Errorf("failed to remove %s: %w", path, err))
+							mu.Unlock()
+						}
+						continue
+					}
+					if c.gitAdd {
+						gitMu.Lock()
+						c.stageRemoval(path)
+						gitMu.Unlock()
+					}
+				}
+
+				mu.Lock()
+				done++
+				c.reportProgress(done, len(files))
+				mu.Unlock()
 			}
+		}()
+	}
+
+	for _, path := range files {
+		if ctx.Err() != nil {
+			break
 		}
+		paths <- path
+	}
+	close(paths)
+	wg.Wait()
 
-		// Check against protected paths
-		relPath, err = filepath.Rel(c.sourceDir, absPath)
-		if err == nil {
-			for _, protectedPath := range c.protectedPaths {
-				// Check if the file is the protected path or is under a protected directory
-				if relPath == protectedPath || strings.HasPrefix(relPath, protectedPath+string(filepath.Separator)) {
-					return nil
-				}
+	return done, errors.Join(errs...)
+}
+
+// removeFile removes path, or moves it to the trash directory if one is set
+func (c *Cleaner) removeFile(path string) error {
+	if c.trashDir == "" {
+		return c.fs.Remove(path)
+	}
+	dst := filepath.Join(c.trashDir, path)
+	if err := c.fs.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return c.fs.Rename(path, dst)
+}
+
+// WouldRemove reports whether Clean would remove the file at absPath. It checks
+// the keep list and the protection rules without walking the source directory.
+func (c *Cleaner) WouldRemove(absPath string) bool {
+	// Nothing is removed when copying to an output directory
+	if c.outputDir != "" {
+		return false
+	}
+
+	relPath, err := filepath.Rel(c.sourceDir, absPath)
+	if err != nil || relPath == "." || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return false
+	}
+	if relPath == SkipFileName || relPath == RollbackFileName {
+		return false
+	}
+	if c.trashDir != "" && (absPath == c.trashDir || strings.HasPrefix(absPath, c.trashDir+string(filepath.Separator))) {
+		return false
+	}
+
+	if skipDirs, err := c.readSkipFile(); err == nil {
+		for _, dir := range strings.Split(filepath.Dir(relPath), string(filepath.Separator)) {
+			if _, skip := skipDirs[dir]; skip {
+				return false
 			}
 		}
+	}
 
-		toRemove = append(toRemove, absPath)
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to walk directory: %v", err)
+	if c.keepRecent > 0 {
+		if info, err := c.fs.Stat(absPath); err == nil && time.Since(info.ModTime()) < c.keepRecent {
+			return false
+		}
 	}
 
-	// Second pass: remove files
-	if !c.dryRun {
-		for _, path := range toRemove {
-			if err := c.fs.Remove(path); err != nil {
-				return fmt.Errorf("failed to remove %s: %v", path, err)
+	if c.checkOwner {
+		if info, err := c.fs.Stat(absPath); err == nil {
+			if _, other := ownedByOther(info); other {
+				return false
 			}
 		}
 	}
 
-	// Third pass: remove empty directories
-	if err := c.removeEmptyDirs(c.sourceDir); err != nil {
-		return fmt.Errorf("failed to clean empty directories: %v", err)
+	if lstater, ok := c.fs.(afero.Lstater); ok {
+		if info, _, err := lstater.LstatIfPossible(absPath); err == nil && isSymlink(info) && c.keepsBelow(absPath) {
+			return false
+		}
 	}
 
-	// Run go mod tidy after cleaning if requested
-	if !c.dryRun && c.runGoModTidy {
-		cmd := exec.Command("go", "mod", "tidy")
-		cmd.Dir = c.sourceDir
-		if out, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to run go mod tidy: %v\nOutput: %s", err, out)
+	return c.shouldRemove(absPath)
+}
+
+// shouldRemove reports whether the file at absPath is neither kept nor protected
+func (c *Cleaner) shouldRemove(absPath string) bool {
+	// Keep files that are in our keep list, except test files in aggressive mode
+	if _, keep := c.filesToKeep[absPath]; keep {
+		if c.mode != ModeAggressive || c.keepTests || !strings.HasSuffix(absPath, "_test.go") {
+			return false
 		}
-		log.Printf("Successfully ran go mod tidy in %s", c.sourceDir)
 	}
 
-	return nil
+	// Keep .git files if protection is enabled
+	if c.protectGit {
+		// Check if the path is .git or is under .git directory
+		relPath, err := filepath.Rel(c.sourceDir, absPath)
+		if err == nil && (relPath == ".git" || strings.HasPrefix(relPath, ".git"+string(filepath.Separator))) {
+			return false
+		}
+	}
+
+	// Handle testdata directories
+	inTestdata := strings.Contains(absPath, "/testdata/")
+
+	// Keep go.mod and go.sum files if protection is enabled (except in testdata)
+	if c.protectGoMod && !inTestdata {
+		base := filepath.Base(absPath)
+		if base == "go.mod" || base == "go.sum" {
+			return false
+		}
+	}
+
+	// Keep go.work and go.work.sum files if protection is enabled (except in testdata)
+	if c.protectWork && !inTestdata {
+		base := filepath.Base(absPath)
+		if base == "go.work" || base == "go.work.sum" {
+			return false
+		}
+	}
+
+	// Keep files excluded from the current build configuration if requested
+	if c.keepExcluded && c.excludedByBuildContext(absPath) {
+		return false
+	}
+
+	// Keep files protected by a user-defined function
+	for _, protect := range c.protectFuncs {
+		if protect(absPath) {
+			return false
+		}
+	}
+
+	// Keep compiled test binaries if requested
+	if c.keepTestBins && isTestBinary(absPath) {
+		return false
+	}
+
+	// Keep files with a preserved content type
+	if len(c.preserveTypes) > 0 && c.hasPreservedContentType(absPath) {
+		return false
+	}
+
+	// Check against protected paths
+	relPath, err := filepath.Rel(c.sourceDir, absPath)
+	if err == nil {
+		for _, protectedPath := range c.protectedPaths {
+			// Check if the file is the protected path or is under a protected directory
+			if relPath == protectedPath || strings.HasPrefix(relPath, protectedPath+string(filepath.Separator)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isTestBinary reports whether path looks like a binary built by go test -c
+func isTestBinary(path string) bool {
+	base := filepath.Base(path)
+	if filepath.Ext(base) == ".test" {
+		return true
+	}
+	return runtime.GOOS != "windows" && filepath.Ext(base) == "" && strings.HasSuffix(base, "_test")
 }
 
-func (c *Cleaner) removeEmptyDirs(path string) error {
+// removeEmptyDirs removes the directories below path left empty by the clean,
+// returning those removed, deepest first, and whether path itself is empty. If
+// dryRun is set nothing is removed and the files in removed are counted as
+// gone.
+func (c *Cleaner) removeEmptyDirs(path string, removed map[string]struct{}, dryRun bool) ([]string, bool, error) {
 	entries, err := afero.ReadDir(c.fs, path)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
-	// First, recursively process subdirectories
+	var pruned []string
+	remaining := 0
 	for _, entry := range entries {
-		if entry.IsDir() {
-			subpath := filepath.Join(path, entry.Name())
-			// Skip .git directory if protected
-			if c.protectGit && entry.Name() == ".git" {
-				continue
-			}
-			if err := c.removeEmptyDirs(subpath); err != nil {
-				return err
+		subpath := filepath.Join(path, entry.Name())
+		if !entry.IsDir() {
+			if absPath, err := filepath.Abs(subpath); err == nil && dryRun {
+				if _, ok := removed[absPath]; ok {
+					continue
+				}
 			}
+			remaining++
+			continue
 		}
-	}
 
-	// Check if directory is empty
-	entries, err = afero.ReadDir(c.fs, path)
-	if err != nil {
-		return err
+		// Skip .git directory if protected
+		if c.protectGit && entry.Name() == ".git" {
+			remaining++
+			continue
+		}
+
+		// Leave the trash directory alone
+		if c.trashDir != "" && subpath == c.trashDir {
+			remaining++
+			continue
+		}
+
+		// First, recursively process subdirectories
+		dirs, empty, err := c.removeEmptyDirs(subpath, removed, dryRun)
+		pruned = append(pruned, dirs...)
+		if err != nil {
+			return pruned, false, err
+		}
+		if !empty {
+			remaining++
+		}
 	}
 
 	// Remove if empty (except source directory)
-	if len(entries) == 0 && path != c.sourceDir {
-		if c.dryRun {
-			return nil
+	if remaining > 0 || path == c.sourceDir {
+		return pruned, remaining == 0, nil
+	}
+	if !dryRun {
+		if err := c.fs.Remove(path); err != nil {
+			return pruned, false, err
 		}
-		return c.fs.Remove(path)
 	}
-
-	return nil
+	return append(pruned, path), true, nil
 }
@@ -1,10 +1,27 @@
 package cleaner
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/sigma/monorepo-hatchet/pkg/pkglist"
+	"github.com/sigma/monorepo-hatchet/pkg/report"
+	"github.com/sigma/monorepo-hatchet/pkg/walker"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCleaner(t *testing.T) {
@@ -34,7 +51,7 @@ func TestCleaner(t *testing.T) {
 	c := NewWithFs("/src", keepFiles, fs)
 
 	// Clean the directory
-	err := c.Clean()
+	_, err := c.Clean()
 	assert.NoError(t, err)
 
 	// Check that only the kept files exist
@@ -53,3 +70,1957 @@ func TestCleaner(t *testing.T) {
 		assert.Equal(t, shouldExist, exists, "File %s existence state is incorrect", file)
 	}
 }
+
+func TestCleaner_Report(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("remove me"), 0644))
+
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs)
+	assert.Nil(t, c.Report())
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	rep := c.Report()
+	assert.False(t, rep.DryRun)
+	assert.Equal(t, []string{"/src/keep.go"}, rep.Kept)
+	assert.Equal(t, []string{"/src/remove.go"}, rep.Removed)
+	assert.Equal(t, int64(len("remove me")), rep.BytesReclaimed)
+}
+
+func TestCleaner_ProgressCallback(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dryRun=%v", dryRun), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for _, file := range []string{"/src/keep.go", "/src/a.go", "/src/b.go", "/src/c.go"} {
+				assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+			}
+
+			var calls [][2]int
+			c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+				WithDryRun(dryRun),
+				WithProgressCallback(func(done, total int) {
+					calls = append(calls, [2]int{done, total})
+				}),
+			)
+			_, err := c.Clean()
+			assert.NoError(t, err)
+
+			assert.Equal(t, [][2]int{{0, 3}, {1, 3}, {2, 3}, {3, 3}}, calls)
+		})
+	}
+}
+
+func TestCleaner_ConcurrentWalker(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	testFiles := []string{
+		"/src/pkg1/file1.go",
+		"/src/pkg1/file2.go",
+		"/src/pkg2/file3.go",
+		"/src/pkg3/sub/file4.go",
+	}
+	for _, file := range testFiles {
+		assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+	}
+
+	c := NewWithFs("/src", []string{"/src/pkg1/file1.go"}, fs,
+		WithWalker(walker.NewConcurrent(fs, 4)),
+	)
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	for _, file := range testFiles {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.Equal(t, file == "/src/pkg1/file1.go", exists, "File %s existence state is incorrect", file)
+	}
+}
+
+func TestCleaner_DryRunFile(t *testing.T) {
+	for _, dryRun := range []bool{true, false} {
+		t.Run(fmt.Sprintf("dryRun=%v", dryRun), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+			assert.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("remove"), 0644))
+
+			c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+				WithDryRun(dryRun),
+				WithDryRunFile("/out/report.json"),
+			)
+			_, err := c.Clean()
+			assert.NoError(t, err)
+
+			data, err := afero.ReadFile(fs, "/out/report.json")
+			assert.NoError(t, err)
+
+			var got report.Report
+			assert.NoError(t, json.Unmarshal(data, &got))
+			assert.Equal(t, dryRun, got.DryRun)
+			assert.Equal(t, []string{"/src/keep.go"}, got.Kept)
+			assert.Equal(t, []string{"/src/remove.go"}, got.Removed)
+
+			exists, err := afero.Exists(fs, "/src/remove.go")
+			assert.NoError(t, err)
+			assert.Equal(t, dryRun, exists)
+		})
+	}
+}
+
+func TestCleaner_DryRunFileStdout(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("remove"), 0644))
+
+	var stdout bytes.Buffer
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+		WithDryRun(true),
+		WithDryRunFile("-"),
+	)
+	c.stdout = &stdout
+	_, err := c.Clean()
+	require.NoError(t, err)
+
+	var got report.Report
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &got))
+	assert.True(t, got.DryRun)
+	assert.Equal(t, []string{"/src/remove.go"}, got.Removed)
+
+	// No file named "-" is created
+	exists, err := afero.Exists(fs, "-")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = afero.Exists(fs, "/src/-")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCleaner_TrashDir(t *testing.T) {
+	for _, trashDir := range []string{"/trash", "/src/.trash"} {
+		t.Run(trashDir, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for _, file := range []string{"/src/keep.go", "/src/remove.go", "/src/pkg/remove.go"} {
+				require.NoError(t, afero.WriteFile(fs, file, []byte(file), 0644))
+			}
+
+			c := NewWithFs("/src", []string{"/src/keep.go"}, fs, WithTrashDir(trashDir))
+			summary, err := c.Clean()
+			require.NoError(t, err)
+			assert.Equal(t, 2, summary.FilesRemoved)
+			assert.Equal(t, 1, summary.DirsRemoved)
+
+			for _, file := range []string{"/src/remove.go", "/src/pkg/remove.go"} {
+				exists, err := afero.Exists(fs, file)
+				require.NoError(t, err)
+				assert.False(t, exists, file)
+
+				data, err := afero.ReadFile(fs, filepath.Join(trashDir, file))
+				require.NoError(t, err)
+				assert.Equal(t, file, string(data))
+			}
+			exists, err := afero.Exists(fs, "/src/keep.go")
+			require.NoError(t, err)
+			assert.True(t, exists)
+			exists, err = afero.DirExists(fs, "/src/pkg")
+			require.NoError(t, err)
+			assert.False(t, exists)
+
+			// A second clean leaves the trash alone
+			summary, err = c.Clean()
+			require.NoError(t, err)
+			assert.Equal(t, 0, summary.FilesRemoved)
+			exists, err = afero.Exists(fs, filepath.Join(trashDir, "/src/pkg/remove.go"))
+			require.NoError(t, err)
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestCleaner_TouchKeptFiles(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	newFs := func(t *testing.T) afero.Fs {
+		fs := afero.NewMemMapFs()
+		for _, file := range []string{"/src/keep.go", "/src/pkg/keep.go", "/src/remove.go"} {
+			require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+			require.NoError(t, fs.Chtimes(file, old, old))
+		}
+		return fs
+	}
+	keep := []string{"/src/keep.go", "/src/pkg/keep.go", "/src/missing.go"}
+
+	t.Run("touched", func(t *testing.T) {
+		fs := newFs(t)
+		before := time.Now()
+		_, err := NewWithFs("/src", keep, fs, WithTouchKeptFiles(true)).Clean()
+		require.NoError(t, err)
+
+		for _, file := range []string{"/src/keep.go", "/src/pkg/keep.go"} {
+			info, err := fs.Stat(file)
+			require.NoError(t, err)
+			assert.False(t, info.ModTime().Before(before), file)
+		}
+		exists, err := afero.Exists(fs, "/src/missing.go")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		fs := newFs(t)
+		logger := &recordingLogger{}
+		_, err := NewWithFs("/src", keep, fs,
+			WithTouchKeptFiles(true),
+			WithDryRun(true),
+			WithLogger(logger),
+		).Clean()
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"Dry run: would touch /src/keep.go",
+			"Dry run: would touch /src/pkg/keep.go",
+		}, logger.messages)
+
+		info, err := fs.Stat("/src/keep.go")
+		require.NoError(t, err)
+		assert.True(t, info.ModTime().Equal(old))
+	})
+}
+
+func TestCleaner_CleaningMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      CleaningMode
+		keepTests bool
+		want      map[string]bool
+	}{
+		{
+			name: "conservative keeps test files in keep list",
+			mode: ModeConservative,
+			want: map[string]bool{
+				"/src/pkg/file.go":      true,
+				"/src/pkg/file_test.go": true,
+				"/src/pkg/other.go":     false,
+			},
+		},
+		{
+			name: "aggressive removes test files in keep list",
+			mode: ModeAggressive,
+			want: map[string]bool{
+				"/src/pkg/file.go":      true,
+				"/src/pkg/file_test.go": false,
+				"/src/pkg/other.go":     false,
+			},
+		},
+		{
+			name:      "aggressive with tests kept",
+			mode:      ModeAggressive,
+			keepTests: true,
+			want: map[string]bool{
+				"/src/pkg/file.go":      true,
+				"/src/pkg/file_test.go": true,
+				"/src/pkg/other.go":     false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for file := range tt.want {
+				assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+			}
+
+			c := NewWithFs("/src", []string{"/src/pkg/file.go", "/src/pkg/file_test.go"}, fs,
+				WithCleaning(tt.mode),
+				WithTestKeeping(tt.keepTests),
+			)
+			_, err := c.Clean()
+			assert.NoError(t, err)
+
+			for file, shouldExist := range tt.want {
+				exists, err := afero.Exists(fs, file)
+				assert.NoError(t, err)
+				assert.Equal(t, shouldExist, exists, "File %s existence state is incorrect", file)
+			}
+		})
+	}
+}
+
+func TestCleaner_ReportRemovedPackages(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+
+	removed := map[string]string{"github.com/test/repo/foo": "!foo/..."}
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+		WithDryRun(true),
+		WithRemovedPackages(removed),
+	)
+	_, err := c.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, removed, c.Report().RemovedPackages)
+}
+
+func TestCleaner_PreserveDirectoryStructure(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		t.Run(fmt.Sprintf("preserve=%v", preserve), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NoError(t, afero.WriteFile(fs, "/src/keep/file.go", []byte("keep"), 0644))
+			assert.NoError(t, afero.WriteFile(fs, "/src/empty/sub/file.go", []byte("remove"), 0644))
+
+			c := NewWithFs("/src", []string{"/src/keep/file.go"}, fs,
+				WithPreserveDirectoryStructure(preserve),
+			)
+			_, err := c.Clean()
+			assert.NoError(t, err)
+
+			exists, err := afero.Exists(fs, "/src/empty/sub/file.go")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			for _, dir := range []string{"/src/empty", "/src/empty/sub"} {
+				exists, err := afero.DirExists(fs, dir)
+				assert.NoError(t, err)
+				assert.Equal(t, preserve, exists, "Directory %s existence state is incorrect", dir)
+			}
+		})
+	}
+}
+
+func TestCleaner_OutputDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		mirror   bool
+		wantMode os.FileMode
+		wantDirs map[string]bool
+	}{
+		{
+			name:     "basic copy",
+			mirror:   false,
+			wantMode: 0644,
+			wantDirs: map[string]bool{
+				"/out/bin":   true,
+				"/out/empty": false,
+			},
+		},
+		{
+			name:     "mirror mode",
+			mirror:   true,
+			wantMode: 0755,
+			wantDirs: map[string]bool{
+				"/out/bin":   true,
+				"/out/empty": true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NoError(t, afero.WriteFile(fs, "/src/bin/tool.sh", []byte("#!/bin/sh"), 0755))
+			assert.NoError(t, afero.WriteFile(fs, "/src/empty/remove.go", []byte("remove"), 0644))
+
+			c := NewWithFs("/src", []string{"/src/bin/tool.sh"}, fs,
+				WithOutputDir("/out"),
+				WithMirrorMode(tt.mirror),
+			)
+			_, err := c.Clean()
+			assert.NoError(t, err)
+
+			// The source directory is left untouched
+			for _, file := range []string{"/src/bin/tool.sh", "/src/empty/remove.go"} {
+				exists, err := afero.Exists(fs, file)
+				assert.NoError(t, err)
+				assert.True(t, exists, "File %s should still exist", file)
+			}
+
+			info, err := fs.Stat("/out/bin/tool.sh")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMode, info.Mode().Perm())
+
+			exists, err := afero.Exists(fs, "/out/empty/remove.go")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			for dir, want := range tt.wantDirs {
+				exists, err := afero.DirExists(fs, dir)
+				assert.NoError(t, err)
+				assert.Equal(t, want, exists, "Directory %s existence state is incorrect", dir)
+			}
+		})
+	}
+}
+
+// MockCommand implements pkglist.Command for testing
+type MockCommand struct {
+	output []byte
+	err    error
+	dir    string
+	dirs   []string // every directory the command was run in
+	env    []string
+}
+
+func (c *MockCommand) SetDir(dir string) {
+	c.dir = dir
+	c.dirs = append(c.dirs, dir)
+}
+
+func (c *MockCommand) SetEnv(env []string) {
+	c.env = env
+}
+
+func (c *MockCommand) Output() ([]byte, error) {
+	return c.output, c.err
+}
+
+func (c *MockCommand) CombinedOutput() ([]byte, error) {
+	return c.output, c.err
+}
+
+// MockCommander implements pkglist.Commander for testing. Unknown commands
+// run fallback, or succeed with no output if it is nil.
+type MockCommander struct {
+	commands map[string]*MockCommand
+	fallback *MockCommand
+	calls    []string
+}
+
+func (c *MockCommander) Command(name string, args ...string) pkglist.Command {
+	key := fmt.Sprintf("%s %v", name, args)
+	c.calls = append(c.calls, key)
+	if cmd, ok := c.commands[key]; ok {
+		return cmd
+	}
+	if c.fallback != nil {
+		return c.fallback
+	}
+	return &MockCommand{}
+}
+
+func TestCleaner_RunVet(t *testing.T) {
+	tests := []struct {
+		name    string
+		vetCmd  *MockCommand
+		wantErr bool
+	}{
+		{
+			name:   "vet passes",
+			vetCmd: &MockCommand{},
+		},
+		{
+			name: "vet fails",
+			vetCmd: &MockCommand{
+				output: []byte("pkg/foo.go:3:2: undefined: bar"),
+				err:    errors.New("exit status 1"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+
+			commander := &MockCommander{
+				commands: map[string]*MockCommand{
+					"go [vet ./...]": tt.vetCmd,
+				},
+			}
+			c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+				WithGoModTidy(true),
+				WithRunVet(true),
+				WithCommander(commander),
+			)
+
+			_, err := c.Clean()
+			assert.Equal(t, []string{"go [mod tidy]", "go [vet ./...]"}, commander.calls)
+			assert.Equal(t, "/src", tt.vetCmd.dir)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+
+			var vetErr *VetError
+			assert.True(t, errors.As(err, &vetErr))
+			assert.Equal(t, "pkg/foo.go:3:2: undefined: bar", vetErr.Output)
+		})
+	}
+}
+
+func TestCleaner_ReadOnlyFilesystem(t *testing.T) {
+	base := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(base, "/src/keep.go", []byte("keep"), 0644))
+	assert.NoError(t, afero.WriteFile(base, "/src/remove.go", []byte("remove"), 0644))
+	fs := afero.NewReadOnlyFs(base)
+
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs)
+	_, err := c.Clean()
+	assert.ErrorIs(t, err, ErrReadOnlyFilesystem)
+
+	// Dry-run does not write and skips the check
+	c = NewWithFs("/src", []string{"/src/keep.go"}, fs, WithDryRun(true))
+	_, err = c.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/src/remove.go"}, c.Report().Removed)
+}
+
+func TestCleaner_PreserveBuildConstraintFiles(t *testing.T) {
+	files := map[string]string{
+		"/src/pkg/main.go":        "package pkg\n",
+		"/src/pkg/ignored.go":     "//go:build ignore\n\npackage pkg\n",
+		"/src/pkg/sys_plan9.go":   "package pkg\n",
+		"/src/pkg/unused.go":      "package pkg\n",
+		"/src/pkg/constrained.go": "//go:build linux || darwin || windows || !plan9\n\npackage pkg\n",
+	}
+
+	tests := []struct {
+		name     string
+		preserve bool
+		want     map[string]bool
+	}{
+		{
+			name: "disabled",
+			want: map[string]bool{
+				"/src/pkg/main.go":        true,
+				"/src/pkg/ignored.go":     false,
+				"/src/pkg/sys_plan9.go":   false,
+				"/src/pkg/unused.go":      false,
+				"/src/pkg/constrained.go": false,
+			},
+		},
+		{
+			name:     "enabled",
+			preserve: true,
+			want: map[string]bool{
+				"/src/pkg/main.go":        true,
+				"/src/pkg/ignored.go":     true,
+				"/src/pkg/sys_plan9.go":   true,
+				"/src/pkg/unused.go":      false,
+				"/src/pkg/constrained.go": false,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for file, content := range files {
+				assert.NoError(t, afero.WriteFile(fs, file, []byte(content), 0644))
+			}
+
+			c := NewWithFs("/src", []string{"/src/pkg/main.go"}, fs,
+				WithPreserveBuildConstraintFiles(tt.preserve),
+			)
+			_, err := c.Clean()
+			assert.NoError(t, err)
+
+			for file, shouldExist := range tt.want {
+				exists, err := afero.Exists(fs, file)
+				assert.NoError(t, err)
+				assert.Equal(t, shouldExist, exists, "File %s existence state is incorrect", file)
+			}
+		})
+	}
+}
+
+func TestCleaner_Manifest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/pkg/b.go", []byte("package b\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/a.go", []byte("package a\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("remove"), 0644))
+	// A stale manifest is not kept and gets replaced
+	assert.NoError(t, afero.WriteFile(fs, "/src/MANIFEST.txt", []byte("stale"), 0644))
+
+	c := NewWithFs("/src", []string{"/src/a.go", "/src/pkg/b.go"}, fs, WithManifest(true))
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	data, err := afero.ReadFile(fs, "/src/MANIFEST.txt")
+	assert.NoError(t, err)
+	assert.Equal(t,
+		"7b39baa38a2ec2b8d111bbbd8e448e80226477ab40105d9d2123d4dc18067438  10  a.go\n"+
+			"983aab874348ab0e62d9fa51e0719b12f570234284c1f21c740bb6d3ca7cf11d  10  pkg/b.go\n",
+		string(data))
+
+	// Dry-run does not write a manifest
+	fs = afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/a.go", []byte("package a\n"), 0644))
+	c = NewWithFs("/src", []string{"/src/a.go"}, fs, WithManifest(true), WithDryRun(true))
+	_, err = c.Clean()
+	assert.NoError(t, err)
+	exists, err := afero.Exists(fs, "/src/MANIFEST.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCleaner_HardLinkDedup(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	out := filepath.Join(root, "out")
+
+	fs := afero.NewOsFs()
+	files := map[string]string{
+		"a/LICENSE": "same content",
+		"b/LICENSE": "same content",
+		"c/other":   "other content",
+	}
+	var keep []string
+	for file, content := range files {
+		path := filepath.Join(src, file)
+		assert.NoError(t, fs.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, afero.WriteFile(fs, path, []byte(content), 0644))
+		keep = append(keep, path)
+	}
+
+	c := NewWithFs(src, keep, fs,
+		WithOutputDir(out),
+		WithHardLinkDedup(true),
+	)
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	stat := func(file string) os.FileInfo {
+		info, err := os.Stat(filepath.Join(out, file))
+		assert.NoError(t, err)
+		return info
+	}
+	assert.True(t, os.SameFile(stat("a/LICENSE"), stat("b/LICENSE")))
+	assert.False(t, os.SameFile(stat("a/LICENSE"), stat("c/other")))
+}
+
+func TestCleaner_KeepRecentlyModified(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Now()
+	ages := map[string]time.Duration{
+		"/src/new.go":     time.Minute,
+		"/src/today.go":   23 * time.Hour,
+		"/src/old.go":     25 * time.Hour,
+		"/src/ancient.go": 30 * 24 * time.Hour,
+	}
+	for file, age := range ages {
+		assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+		assert.NoError(t, fs.Chtimes(file, now.Add(-age), now.Add(-age)))
+	}
+
+	c := NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true), WithKeepRecentlyModified(24*time.Hour))
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	for file, age := range ages {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.Equal(t, age < 24*time.Hour, exists, "File %s existence state is incorrect", file)
+	}
+}
+
+func TestCleaner_ProtectedPathsEnv(t *testing.T) {
+	setup := func() afero.Fs {
+		fs := afero.NewMemMapFs()
+		for _, file := range []string{"/src/Makefile", "/src/scripts/build.sh", "/src/docs/README.md"} {
+			assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+		}
+		return fs
+	}
+	exists := func(fs afero.Fs, file string) bool {
+		ok, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		return ok
+	}
+
+	t.Setenv(ProtectedPathsEnv, "Makefile:scripts")
+
+	// The environment is used when no paths are set explicitly
+	fs := setup()
+	_, err := NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true)).Clean()
+	assert.NoError(t, err)
+	assert.True(t, exists(fs, "/src/Makefile"))
+	assert.True(t, exists(fs, "/src/scripts/build.sh"))
+	assert.False(t, exists(fs, "/src/docs/README.md"))
+
+	// Explicit paths override the environment
+	fs = setup()
+	_, err = NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true), WithProtectedPaths([]string{"docs"})).Clean()
+	assert.NoError(t, err)
+	assert.False(t, exists(fs, "/src/Makefile"))
+	assert.False(t, exists(fs, "/src/scripts/build.sh"))
+	assert.True(t, exists(fs, "/src/docs/README.md"))
+}
+
+func TestCleaner_GitNewFileWarning(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	commitTime := time.Unix(1700000000, 0)
+	fs := afero.NewMemMapFs()
+	for file, mtime := range map[string]time.Time{
+		"/src/old.go": commitTime.Add(-time.Hour),
+		"/src/new.go": commitTime.Add(time.Hour),
+	} {
+		assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+		assert.NoError(t, fs.Chtimes(file, mtime, mtime))
+	}
+
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"git [log -1 --format=%at]": {output: []byte("1700000000\n")},
+		},
+	}
+	c := NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true),
+		WithGitNewFileWarning(true),
+		WithCommander(commander),
+	)
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	assert.Contains(t, logs.String(), "Warning: removing /src/new.go which was modified after the last commit")
+	assert.NotContains(t, logs.String(), "Warning: removing /src/old.go")
+
+	// Both files are still removed
+	for _, file := range []string{"/src/old.go", "/src/new.go"} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	}
+}
+
+func TestCleaner_GitAdd(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, file := range []string{"/src/keep.go", "/src/tracked.go", "/src/untracked.go"} {
+		assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+	}
+
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"git [rm --cached --quiet -- untracked.go]": {
+				output: []byte("fatal: pathspec 'untracked.go' did not match any files"),
+				err:    errors.New("exit status 128"),
+			},
+		},
+	}
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+		WithGitAdd(true),
+		WithCommander(commander),
+	)
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"git [rm --cached --quiet -- tracked.go]",
+		"git [rm --cached --quiet -- untracked.go]",
+	}, commander.calls)
+
+	exists, err := afero.Exists(fs, "/src/untracked.go")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCleaner_ModuleDirs(t *testing.T) {
+	tests := []struct {
+		name      string
+		outputDir string
+		want      []string
+	}{
+		{
+			name: "in place",
+			want: []string{"/src/a", "/src/b"},
+		},
+		{
+			name:      "output dir",
+			outputDir: "/out",
+			want:      []string{"/out/a", "/out/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for _, file := range []string{"/src/a/go.mod", "/src/a/a.go", "/src/b/go.mod", "/src/b/b.go"} {
+				assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+			}
+
+			tidy := &MockCommand{}
+			commander := &MockCommander{
+				commands: map[string]*MockCommand{
+					"go [mod tidy]": tidy,
+				},
+			}
+			c := NewWithFs("/src", []string{"/src/a/a.go", "/src/b/b.go"}, fs,
+				WithGoModTidy(true),
+				WithModuleDirs([]string{"/src/a", "/src/b"}),
+				WithOutputDir(tt.outputDir),
+				WithCommander(commander),
+			)
+			_, err := c.Clean()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, tidy.dirs)
+		})
+	}
+}
+
+func TestCleaner_SkipFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := []string{
+		"/src/keep.go",
+		"/src/remove.go",
+		"/src/vendor/dep/dep.go",
+		"/src/pkg/third_party/lib.go",
+		"/src/pkg/remove.go",
+	}
+	for _, file := range files {
+		assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+	}
+	assert.NoError(t, afero.WriteFile(fs, "/src/.hatchetskip", []byte("# skipped directories\nvendor\n\nthird_party/\n"), 0644))
+
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs)
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	for file, want := range map[string]bool{
+		"/src/keep.go":                true,
+		"/src/remove.go":              false,
+		"/src/vendor/dep/dep.go":      true,
+		"/src/pkg/third_party/lib.go": true,
+		"/src/pkg/remove.go":          false,
+		"/src/.hatchetskip":           true,
+	} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, file)
+	}
+	assert.NotContains(t, c.Report().Kept, "/src/vendor/dep/dep.go")
+}
+
+func TestCleaner_WithFsType(t *testing.T) {
+	c := New("/src", nil)
+	assert.IsType(t, &afero.OsFs{}, c.fs)
+
+	c = New("/src", nil, WithFsType(FsTypeMemory))
+	assert.IsType(t, &afero.MemMapFs{}, c.fs)
+
+	c = New("/src", nil, WithFsType(FsTypeReadOnly), WithAllowEmptyKeepList(true))
+	assert.IsType(t, &afero.ReadOnlyFs{}, c.fs)
+	_, err := c.Clean()
+	assert.ErrorIs(t, err, ErrReadOnlyFilesystem)
+
+	c = New("/src", nil, WithFsType(FsTypeMemory), WithFsType(FsTypeOS))
+	assert.IsType(t, &afero.OsFs{}, c.fs)
+}
+
+func TestCleaner_WouldRemove(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/.hatchetskip", []byte("vendor\n"), 0644))
+
+	c := NewWithFs("/src", []string{"/src/keep.go", "/src/keep_test.go"}, fs,
+		WithProtectedPaths([]string{"docs"}),
+		WithCleaning(ModeAggressive),
+	)
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/src/keep.go", false},
+		{"/src/keep_test.go", true},
+		{"/src/remove.go", true},
+		{"/src/pkg/remove.go", true},
+		{"/src/.git/config", false},
+		{"/src/go.mod", false},
+		{"/src/testdata/go.mod", true},
+		{"/src/docs/README.md", false},
+		{"/src/vendor/dep/dep.go", false},
+		{"/src/.hatchetskip", false},
+		{"/other/remove.go", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, c.WouldRemove(tt.path), tt.path)
+	}
+
+	// WouldRemove agrees with Clean
+	for _, tt := range tests {
+		if tt.path != "/src/.hatchetskip" {
+			assert.NoError(t, afero.WriteFile(fs, tt.path, []byte("test content"), 0644))
+		}
+	}
+	_, err := c.Clean()
+	assert.NoError(t, err)
+	for _, tt := range tests {
+		exists, err := afero.Exists(fs, tt.path)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, !exists, tt.path)
+	}
+}
+
+func TestCleaner_WorkspaceProtection(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantWorkDel bool
+	}{
+		{"protected by default", nil, false},
+		{"protection disabled", []Option{WithWorkspaceProtection(false)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			for _, file := range []string{
+				"/src/go.work",
+				"/src/go.work.sum",
+				"/src/app/go.mod",
+				"/src/app/main.go",
+				"/src/lib/go.mod",
+				"/src/lib/lib.go",
+				"/src/lib/testdata/go.work",
+			} {
+				require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+			}
+
+			c := NewWithFs("/src", []string{"/src/app/main.go"}, fs, tt.opts...)
+			_, err := c.Clean()
+			require.NoError(t, err)
+
+			for _, file := range []string{"/src/app/go.mod", "/src/app/main.go"} {
+				exists, err := afero.Exists(fs, file)
+				require.NoError(t, err)
+				assert.True(t, exists, file)
+			}
+			for _, file := range []string{"/src/go.work", "/src/go.work.sum"} {
+				exists, err := afero.Exists(fs, file)
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantWorkDel, !exists, file)
+			}
+			for _, file := range []string{"/src/lib/lib.go", "/src/lib/testdata/go.work"} {
+				exists, err := afero.Exists(fs, file)
+				require.NoError(t, err)
+				assert.False(t, exists, file)
+			}
+		})
+	}
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...any) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestCleaner_WithLogger(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	newFs := func() afero.Fs {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("test content"), 0644))
+		return fs
+	}
+	opts := []Option{WithDryRun(true), WithCompressionAfterClean(ArchiveTarGz, "/out/src.tar.gz")}
+
+	logger := &recordingLogger{}
+	c := NewWithFs("/src", []string{"/src/keep.go"}, newFs(), append(opts, WithLogger(logger))...)
+	_, err := c.Clean()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Dry run: skipping compression to /out/src.tar.gz"}, logger.messages)
+
+	c = NewWithFs("/src", []string{"/src/keep.go"}, newFs(), append(opts, WithLogger(pkglist.NopLogger{}))...)
+	_, err = c.Clean()
+	require.NoError(t, err)
+	assert.Empty(t, logs.String())
+
+	// Without a logger, messages go to the standard logger
+	c = NewWithFs("/src", []string{"/src/keep.go"}, newFs(), opts...)
+	_, err = c.Clean()
+	require.NoError(t, err)
+	assert.Contains(t, logs.String(), "Dry run: skipping compression to /out/src.tar.gz")
+}
+
+func TestCleaner_ParallelRemove(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	var files []string
+	for i := 0; i < 20; i++ {
+		file := fmt.Sprintf("/src/pkg%d/file.go", i)
+		files = append(files, file)
+		assert.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+	}
+	assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+
+	var calls [][2]int
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+		WithParallelRemove(4),
+		WithProgressCallback(func(done, total int) {
+			calls = append(calls, [2]int{done, total})
+		}),
+	)
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	for _, file := range files {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.False(t, exists, file)
+	}
+	exists, err := afero.Exists(fs, "/src/keep.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Progress is still reported in order, once per file
+	assert.Len(t, calls, len(files)+1)
+	for i, call := range calls {
+		assert.Equal(t, [2]int{i, len(files)}, call)
+	}
+}
+
+func TestCleaner_ParallelRemoveError(t *testing.T) {
+	base := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(base, "/src/remove.go", []byte("remove"), 0644))
+
+	fs := &failingRemoveFs{Fs: base, fail: map[string]bool{"/src/remove.go": true}}
+	c := NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true), WithParallelRemove(4))
+	_, err := c.Clean()
+	assert.ErrorContains(t, err, "failed to remove /src/remove.go")
+}
+
+// symlinkFs simulates symlinks on top of another filesystem. Each link is
+// backed by a placeholder file so that it shows up in directory listings.
+type symlinkFs struct {
+	afero.Fs
+	links map[string]string // absolute link path to absolute target
+}
+
+func newSymlinkFs(t *testing.T, base afero.Fs, links map[string]string) *symlinkFs {
+	for link := range links {
+		require.NoError(t, afero.WriteFile(base, link, nil, 0644))
+	}
+	return &symlinkFs{Fs: base, links: links}
+}
+
+// resolve replaces a symlink at the start of name by its target, following
+// name itself only if follow is set
+func (f *symlinkFs) resolve(name string, follow bool) string {
+	for link, target := range f.links {
+		if strings.HasPrefix(name, link+"/") || (follow && name == link) {
+			return target + name[len(link):]
+		}
+	}
+	return name
+}
+
+func (f *symlinkFs) Stat(name string) (fs.FileInfo, error) {
+	return f.Fs.Stat(f.resolve(name, true))
+}
+
+func (f *symlinkFs) Open(name string) (afero.File, error) {
+	return f.Fs.Open(f.resolve(name, true))
+}
+
+func (f *symlinkFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return f.Fs.OpenFile(f.resolve(name, true), flag, perm)
+}
+
+func (f *symlinkFs) Remove(name string) error {
+	if _, ok := f.links[name]; ok {
+		delete(f.links, name)
+	}
+	return f.Fs.Remove(f.resolve(name, false))
+}
+
+func (f *symlinkFs) LstatIfPossible(name string) (fs.FileInfo, bool, error) {
+	if _, ok := f.links[name]; ok {
+		return symlinkInfo(filepath.Base(name)), true, nil
+	}
+	info, err := f.Fs.Stat(f.resolve(name, false))
+	return info, true, err
+}
+
+func (f *symlinkFs) ReadlinkIfPossible(name string) (string, error) {
+	if target, ok := f.links[name]; ok {
+		return target, nil
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+}
+
+type symlinkInfo string
+
+func (i symlinkInfo) Name() string       { return string(i) }
+func (i symlinkInfo) Size() int64        { return 0 }
+func (i symlinkInfo) Mode() fs.FileMode  { return fs.ModeSymlink | 0777 }
+func (i symlinkInfo) ModTime() time.Time { return time.Time{} }
+func (i symlinkInfo) IsDir() bool        { return false }
+func (i symlinkInfo) Sys() any           { return nil }
+
+func TestCleaner_Symlinks(t *testing.T) {
+	newFs := func(t *testing.T) *symlinkFs {
+		base := afero.NewMemMapFs()
+		for _, file := range []string{
+			"/src/keep.go",
+			"/src/remove.go",
+			"/outside/target.go",
+			"/outside/dir/a.go",
+			"/outside/dir/b.go",
+			"/outside/lib/lib.go",
+		} {
+			require.NoError(t, afero.WriteFile(base, file, []byte(file), 0644))
+		}
+		return newSymlinkFs(t, base, map[string]string{
+			"/src/linkfile.go": "/outside/target.go",
+			"/src/linkdir":     "/outside/dir",
+			"/src/lib":         "/outside/lib",
+			"/src/loop":        "/src",
+		})
+	}
+	outside := []string{"/outside/target.go", "/outside/dir/a.go", "/outside/dir/b.go", "/outside/lib/lib.go"}
+
+	t.Run("not followed by default", func(t *testing.T) {
+		fs := newFs(t)
+		c := NewWithFs("/src", []string{"/src/keep.go", "/src/lib/lib.go"}, fs)
+		assert.True(t, c.WouldRemove("/src/linkdir"))
+		assert.False(t, c.WouldRemove("/src/lib"))
+
+		_, err := c.Clean()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"/src/linkdir", "/src/linkfile.go", "/src/loop", "/src/remove.go"}, c.Report().Removed)
+		assert.ElementsMatch(t, []string{"/src/keep.go", "/src/lib"}, c.Report().Kept)
+
+		// The links are gone but none of their targets were touched
+		assert.Equal(t, map[string]string{"/src/lib": "/outside/lib"}, fs.links)
+		for _, file := range outside {
+			exists, err := afero.Exists(fs, file)
+			require.NoError(t, err)
+			assert.True(t, exists, file)
+		}
+	})
+
+	t.Run("followed", func(t *testing.T) {
+		fs := newFs(t)
+		c := NewWithFs("/src", []string{"/src/keep.go", "/src/linkdir/a.go", "/src/lib/lib.go"}, fs,
+			WithSymlinkFollowing(true),
+		)
+		_, err := c.Clean()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"/src/linkdir/b.go", "/src/linkfile.go", "/src/loop", "/src/remove.go"}, c.Report().Removed)
+		assert.ElementsMatch(t, []string{"/src/keep.go", "/src/linkdir/a.go", "/src/lib/lib.go"}, c.Report().Kept)
+
+		for _, file := range outside {
+			exists, err := afero.Exists(fs, file)
+			require.NoError(t, err)
+			assert.Equal(t, file != "/outside/dir/b.go", exists, file)
+		}
+	})
+}
+
+func BenchmarkCleaner_Remove(b *testing.B) {
+	for _, parallel := range []int{1, 8} {
+		b.Run(fmt.Sprintf("parallel=%d", parallel), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				fs := afero.NewMemMapFs()
+				for j := 0; j < 1000; j++ {
+					if err := afero.WriteFile(fs, fmt.Sprintf("/src/pkg%d/file%d.go", j%50, j), []byte("test content"), 0644); err != nil {
+						b.Fatal(err)
+					}
+				}
+				c := NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true), WithParallelRemove(parallel))
+				b.StartTimer()
+
+				if _, err := c.Clean(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCleaner_PreserveByContentType(t *testing.T) {
+	// 1x1 transparent PNG
+	png := []byte{
+		0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d,
+		0x49, 0x48, 0x44, 0x52, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+		0x08, 0x06, 0x00, 0x00, 0x00, 0x1f, 0x15, 0xc4, 0x89, 0x00, 0x00, 0x00,
+		0x0d, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0x00, 0x01, 0x00, 0x00,
+		0x05, 0x00, 0x01, 0x0d, 0x0a, 0x2d, 0xb4, 0x00, 0x00, 0x00, 0x00, 0x49,
+		0x45, 0x4e, 0x44, 0xae, 0x42, 0x60, 0x82,
+	}
+
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/assets/logo.png", png, 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/assets/logo.dat", png, 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/doc.pdf", []byte("%PDF-1.4\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("package remove\n"), 0644))
+
+	c := NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true), WithPreserveByContentType([]string{"image/png"}))
+	_, err := c.Clean()
+	assert.NoError(t, err)
+
+	for file, want := range map[string]bool{
+		"/src/assets/logo.png": true,
+		"/src/assets/logo.dat": true, // sniffed from content, not the extension
+		"/src/doc.pdf":         false,
+		"/src/remove.go":       false,
+	} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, file)
+	}
+}
+
+// failingStatFs fails Stat for the given paths. It hides MemMapFs's
+// LstatIfPossible so walks go through Stat.
+type failingStatFs struct {
+	afero.Fs
+	fail map[string]bool
+}
+
+func (f *failingStatFs) Stat(name string) (os.FileInfo, error) {
+	if f.fail[name] {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return f.Fs.Stat(name)
+}
+
+func TestCleaner_WalkErrors(t *testing.T) {
+	base := afero.NewMemMapFs()
+	for _, file := range []string{"/src/keep.go", "/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go"} {
+		assert.NoError(t, afero.WriteFile(base, file, []byte("test content"), 0644))
+	}
+	fs := &failingStatFs{
+		Fs:   &failingRemoveFs{Fs: base, fail: map[string]bool{"/src/d.go": true}},
+		fail: map[string]bool{"/src/a.go": true, "/src/b.go": true},
+	}
+
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs)
+	_, err := c.Clean()
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.ErrorContains(t, err, "failed to stat /src/a.go")
+	assert.ErrorContains(t, err, "failed to stat /src/b.go")
+	assert.ErrorContains(t, err, "failed to remove /src/d.go")
+
+	// Files after the failures are still processed
+	for file, want := range map[string]bool{
+		"/src/keep.go": true,
+		"/src/a.go":    true,
+		"/src/b.go":    true,
+		"/src/c.go":    false,
+		"/src/d.go":    true,
+	} {
+		exists, err := afero.Exists(base, file)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, file)
+	}
+}
+
+func TestCleaner_BuildVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		buildCmd *MockCommand
+		wantErr  bool
+	}{
+		{
+			name:     "build passes",
+			buildCmd: &MockCommand{},
+		},
+		{
+			name: "build fails",
+			buildCmd: &MockCommand{
+				output: []byte("keep.go:5:2: undefined: helper"),
+				err:    errors.New("exit status 1"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+			assert.NoError(t, afero.WriteFile(fs, "/src/helper.go", []byte("helper"), 0644))
+
+			// The overlay file name is random, so match the build as the fallback
+			commander := &MockCommander{fallback: tt.buildCmd}
+			c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+				WithBuildVerify(true),
+				WithCommander(commander),
+			)
+
+			_, err := c.Clean()
+			require.Len(t, commander.calls, 1)
+			assert.Regexp(t, `^go \[build -overlay \S+hatchet-overlay-\d+\.json \./\.\.\.\]$`, commander.calls[0])
+			assert.Equal(t, "/src", tt.buildCmd.dir)
+
+			exists, existsErr := afero.Exists(fs, "/src/helper.go")
+			assert.NoError(t, existsErr)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				assert.False(t, exists)
+				return
+			}
+
+			var buildErr *BuildVerifyError
+			assert.True(t, errors.As(err, &buildErr))
+			assert.Equal(t, "keep.go:5:2: undefined: helper", buildErr.Output)
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestBuildOverlay(t *testing.T) {
+	data, err := buildOverlay([]string{"/src/a.go", "/src/b.go"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Replace": {"/src/a.go": "", "/src/b.go": ""}}`, string(data))
+}
+
+func TestCleaner_DefaultWalker(t *testing.T) {
+	assert.IsType(t, &walker.DirWalker{}, New("/src", nil).defaultWalker())
+	assert.IsType(t, &walker.FsWalker{}, NewWithFs("/src", nil, afero.NewMemMapFs()).defaultWalker())
+}
+
+func TestCleaner_ChecksumVerify(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/a.go", []byte("package a\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/pkg/b.go", []byte("package b\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("remove"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/manifest.txt", []byte(
+		"7b39baa38a2ec2b8d111bbbd8e448e80226477ab40105d9d2123d4dc18067438  10  a.go\n"+
+			"983aab874348ab0e62d9fa51e0719b12f570234284c1f21c740bb6d3ca7cf11d  10  pkg/b.go\n",
+	), 0644))
+	keep := []string{"/src/a.go", "/src/pkg/b.go"}
+
+	// Tampered file
+	assert.NoError(t, afero.WriteFile(fs, "/src/pkg/b.go", []byte("package evil\n"), 0644))
+	c := NewWithFs("/src", keep, fs, WithChecksumVerify("/manifest.txt"))
+	_, err := c.Clean()
+	var mismatch *ChecksumMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, "/src/pkg/b.go", mismatch.Path)
+	assert.Equal(t, "983aab874348ab0e62d9fa51e0719b12f570234284c1f21c740bb6d3ca7cf11d", mismatch.Expected)
+	assert.NotEqual(t, mismatch.Expected, mismatch.Actual)
+
+	exists, err := afero.Exists(fs, "/src/remove.go")
+	assert.NoError(t, err)
+	assert.True(t, exists, "nothing is removed on mismatch")
+
+	// File missing from the manifest
+	assert.NoError(t, afero.WriteFile(fs, "/src/pkg/b.go", []byte("package b\n"), 0644))
+	assert.NoError(t, afero.WriteFile(fs, "/src/c.go", []byte("package c\n"), 0644))
+	c = NewWithFs("/src", append(keep, "/src/c.go"), fs, WithChecksumVerify("/manifest.txt"))
+	_, err = c.Clean()
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, "/src/c.go", mismatch.Path)
+	assert.Empty(t, mismatch.Expected)
+
+	// Untouched files
+	c = NewWithFs("/src", keep, fs, WithChecksumVerify("/manifest.txt"))
+	_, err = c.Clean()
+	assert.NoError(t, err)
+	exists, err = afero.Exists(fs, "/src/remove.go")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestCleaner_Summary(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dryRun=%v", dryRun), func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			files := map[string]string{
+				"/src/keep.go":          "keep",
+				"/src/remove.go":        "remove",
+				"/src/pkg/keep.go":      "keep",
+				"/src/pkg/remove.go":    "remove",
+				"/src/empty/a/file.go":  "12345",
+				"/src/empty/b/file.go":  "12345",
+				"/src/empty/b/other.go": "12345",
+			}
+			for file, content := range files {
+				assert.NoError(t, afero.WriteFile(fs, file, []byte(content), 0644))
+			}
+
+			c := NewWithFs("/src", []string{"/src/keep.go", "/src/pkg/keep.go"}, fs, WithDryRun(dryRun))
+			summary, err := c.Clean()
+			assert.NoError(t, err)
+			assert.Equal(t, CleanSummary{
+				FilesRemoved: 5,
+				BytesFreed:   27,
+				DirsRemoved:  3, // empty/a, empty/b and empty
+				FilesKept:    2,
+			}, summary)
+
+			exists, err := afero.DirExists(fs, "/src/empty")
+			assert.NoError(t, err)
+			assert.Equal(t, dryRun, exists)
+		})
+	}
+}
+
+func TestCleaner_RunStaticcheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		checkCmd *MockCommand
+		wantErr  bool
+	}{
+		{
+			name:     "staticcheck passes",
+			checkCmd: &MockCommand{},
+		},
+		{
+			name:     "staticcheck not installed",
+			checkCmd: &MockCommand{err: &exec.Error{Name: "staticcheck", Err: exec.ErrNotFound}},
+		},
+		{
+			name: "staticcheck fails",
+			checkCmd: &MockCommand{
+				output: []byte("keep.go:3:6: func unused is unused (U1000)"),
+				err:    errors.New("exit status 1"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			assert.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("keep"), 0644))
+
+			commander := &MockCommander{
+				commands: map[string]*MockCommand{
+					"staticcheck [./...]": tt.checkCmd,
+				},
+			}
+			c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+				WithRunStaticcheck(true),
+				WithCommander(commander),
+			)
+
+			_, err := c.Clean()
+			assert.Equal(t, []string{"staticcheck [./...]"}, commander.calls)
+			assert.Equal(t, "/src", tt.checkCmd.dir)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+
+			var checkErr *StaticcheckError
+			assert.True(t, errors.As(err, &checkErr))
+			assert.Equal(t, "keep.go:3:6: func unused is unused (U1000)", checkErr.Output)
+		})
+	}
+}
+
+// slowWalker sleeps before visiting each entry
+type slowWalker struct {
+	walker.Walker
+	delay time.Duration
+}
+
+func (w *slowWalker) Walk(root string, fn walker.WalkFunc) error {
+	return w.Walker.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		time.Sleep(w.delay)
+		return fn(path, info, err)
+	})
+}
+
+func TestCleaner_CleanContext(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, afero.WriteFile(memFs, fmt.Sprintf("/src/file%d.go", i), []byte("test content"), 0644))
+	}
+
+	c := NewWithFs("/src", nil, memFs, WithAllowEmptyKeepList(true),
+		WithWalker(&slowWalker{Walker: walker.New(memFs), delay: 10 * time.Millisecond}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.CleanContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// Nothing is removed when the walk is interrupted
+	exists, err := afero.Exists(memFs, "/src/file0.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestCleaner_CleanContextCancelledRemoval(t *testing.T) {
+	memFs := afero.NewMemMapFs()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, afero.WriteFile(memFs, fmt.Sprintf("/src/file%d.go", i), []byte("test content"), 0644))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel after the third removal
+	c := NewWithFs("/src", nil, memFs, WithAllowEmptyKeepList(true),
+		WithProgressCallback(func(done, total int) {
+			if done == 3 {
+				cancel()
+			}
+		}),
+	)
+	summary, err := c.CleanContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 3, summary.FilesRemoved)
+
+	files, err := afero.ReadDir(memFs, "/src")
+	assert.NoError(t, err)
+	assert.Len(t, files, 7)
+}
+
+func TestCleaner_ConcurrencyCollectsErrors(t *testing.T) {
+	base := afero.NewMemMapFs()
+	fail := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		file := fmt.Sprintf("/src/file%d.go", i)
+		assert.NoError(t, afero.WriteFile(base, file, []byte("test content"), 0644))
+		if i%5 == 0 {
+			fail[file] = true
+		}
+	}
+
+	c := NewWithFs("/src", nil, &failingRemoveFs{Fs: base, fail: fail}, WithAllowEmptyKeepList(true), WithConcurrency(4))
+	summary, err := c.Clean()
+	for file := range fail {
+		assert.ErrorContains(t, err, "failed to remove "+file)
+	}
+	assert.Equal(t, 16, summary.FilesRemoved)
+
+	files, err := afero.ReadDir(base, "/src")
+	assert.NoError(t, err)
+	assert.Len(t, files, len(fail))
+}
+
+func TestCleaner_ErrorHandler(t *testing.T) {
+	base := afero.NewMemMapFs()
+	fail := map[string]bool{"/src/locked.go": true, "/src/pkg/denied.go": true}
+	for _, file := range []string{"/src/keep.go", "/src/locked.go", "/src/remove.go", "/src/pkg/denied.go", "/src/pkg/remove.go"} {
+		require.NoError(t, afero.WriteFile(base, file, []byte("test content"), 0644))
+	}
+
+	failed := make(map[string]error)
+	c := NewWithFs("/src", []string{"/src/keep.go"}, &failingRemoveFs{Fs: base, fail: fail},
+		WithConcurrency(4),
+		WithErrorHandler(func(path string, err error) {
+			failed[path] = err
+		}),
+	)
+	summary, err := c.Clean()
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.FilesRemoved)
+
+	require.Len(t, failed, 2)
+	for file := range fail {
+		assert.ErrorIs(t, failed[file], os.ErrPermission, file)
+	}
+	for _, file := range []string{"/src/remove.go", "/src/pkg/remove.go"} {
+		exists, err := afero.Exists(base, file)
+		require.NoError(t, err)
+		assert.False(t, exists, file)
+	}
+}
+
+func TestCleaner_SlowErrorHandler(t *testing.T) {
+	base := afero.NewMemMapFs()
+	var files []string
+	for i := 0; i < 10; i++ {
+		file := fmt.Sprintf("/src/file%d.go", i)
+		files = append(files, file)
+		require.NoError(t, afero.WriteFile(base, file, []byte("test content"), 0644))
+	}
+
+	// The handler blocks until every other file is removed, which only
+	// happens if it does not stop the other workers
+	othersRemoved := make(chan struct{})
+	c := NewWithFs("/src", nil, &failingRemoveFs{Fs: base, fail: map[string]bool{files[0]: true}},
+		WithAllowEmptyKeepList(true),
+		WithConcurrency(2),
+		WithProgressCallback(func(done, total int) {
+			if done == len(files)-1 {
+				close(othersRemoved)
+			}
+		}),
+		WithErrorHandler(func(path string, err error) {
+			select {
+			case <-othersRemoved:
+			case <-time.After(5 * time.Second):
+				t.Error("error handler blocked the other workers")
+			}
+		}),
+	)
+	summary, err := c.Clean()
+	require.NoError(t, err)
+	assert.Equal(t, len(files)-1, summary.FilesRemoved)
+}
+
+func TestCleaner_EmptyKeepList(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/file.go", []byte("test content"), 0644))
+
+	_, err := NewWithFs("/src", nil, fs).Clean()
+	assert.ErrorIs(t, err, ErrEmptyKeepList)
+	exists, err := afero.Exists(fs, "/src/file.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	summary, err := NewWithFs("/src", nil, fs, WithAllowEmptyKeepList(true)).Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.FilesRemoved)
+	exists, err = afero.Exists(fs, "/src/file.go")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+const replaceFixture = `module example.com/repo
+
+go 1.22
+
+require (
+	example.com/lib v1.0.0
+	example.com/other v1.2.0
+)
+
+replace example.com/lib => ./third_party/lib
+`
+
+func TestCleaner_GoModReplace(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for file, content := range map[string]string{
+		"/src/go.mod":               replaceFixture,
+		"/src/main.go":              "package main",
+		"/src/third_party/lib/a.go": "package lib",
+		"/src/vendored/lib/go.mod":  "module example.com/lib",
+		"/src/forks/other/go.mod":   "module example.com/other",
+	} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte(content), 0644))
+	}
+
+	keep := []string{"/src/go.mod", "/src/main.go", "/src/vendored/lib/go.mod", "/src/forks/other/go.mod"}
+	c := NewWithFs("/src", keep, fs,
+		WithGoModReplace("example.com/lib", "./vendored/lib"),
+		WithGoModReplace("example.com/other", "./forks/other"),
+	)
+	_, err := c.Clean()
+	require.NoError(t, err)
+
+	data, err := afero.ReadFile(fs, "/src/go.mod")
+	require.NoError(t, err)
+	assert.Equal(t, `module example.com/repo
+
+go 1.22
+
+require (
+	example.com/lib v1.0.0
+	example.com/other v1.2.0
+)
+
+replace example.com/lib => ./vendored/lib
+
+replace example.com/other => ./forks/other
+`, string(data))
+}
+
+func TestCleaner_GoModReplaceMissingPath(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/go.mod", []byte(replaceFixture), 0644))
+
+	c := NewWithFs("/src", []string{"/src/go.mod"}, fs, WithGoModReplace("example.com/lib", "./missing"))
+	_, err := c.Clean()
+	assert.ErrorContains(t, err, "failed to update go.mod replace directives: replacement for example.com/lib")
+
+	data, err := afero.ReadFile(fs, "/src/go.mod")
+	require.NoError(t, err)
+	assert.Equal(t, replaceFixture, string(data))
+}
+
+func TestCleaner_PreserveTestBinaries(t *testing.T) {
+	files := []string{
+		"/src/pkg1/file.go",
+		"/src/pkg1/pkg1.test",
+		"/src/pkg1/pkg1_test",
+		"/src/pkg1/notes.txt",
+		"/src/pkg1/helper_test.sh",
+	}
+	setup := func() afero.Fs {
+		fs := afero.NewMemMapFs()
+		for _, file := range files {
+			require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0755))
+		}
+		return fs
+	}
+	keep := []string{"/src/pkg1/file.go"}
+
+	fs := setup()
+	_, err := NewWithFs("/src", keep, fs, WithPreserveTestBinaries(true)).Clean()
+	require.NoError(t, err)
+	for file, want := range map[string]bool{
+		"/src/pkg1/file.go":        true,
+		"/src/pkg1/pkg1.test":      true,
+		"/src/pkg1/pkg1_test":      runtime.GOOS != "windows",
+		"/src/pkg1/notes.txt":      false,
+		"/src/pkg1/helper_test.sh": false,
+	} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, file)
+	}
+
+	// Test binaries are removed by default
+	fs = setup()
+	_, err = NewWithFs("/src", keep, fs).Clean()
+	require.NoError(t, err)
+	for _, file := range []string{"/src/pkg1/pkg1.test", "/src/pkg1/pkg1_test"} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.False(t, exists, file)
+	}
+}
+
+func TestCleaner_DiffTree(t *testing.T) {
+	setup := func() afero.Fs {
+		fs := afero.NewMemMapFs()
+		for _, file := range []string{
+			"/src/go.mod",
+			"/src/.git/HEAD",
+			"/src/pkg1/keep.go",
+			"/src/pkg1/remove.go",
+			"/src/pkg2/remove.go",
+		} {
+			require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+		}
+		return fs
+	}
+	keep := []string{"/src/pkg1/keep.go"}
+	want := `--- before
++++ after
+@@ -1,7 +1,4 @@
+ .git/
+ go.mod
+ pkg1/
+ pkg1/keep.go
+-pkg1/remove.go
+-pkg2/
+-pkg2/remove.go
+`
+
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dry run %v", dryRun), func(t *testing.T) {
+			var buf bytes.Buffer
+			c := NewWithFs("/src", keep, setup(), WithDryRun(dryRun), WithDiffTree(&buf))
+			_, err := c.Clean()
+			require.NoError(t, err)
+			assert.Equal(t, want, buf.String())
+		})
+	}
+}
+
+func TestCleaner_DryRunCallback(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, file := range []string{"/src/keep.go", "/src/remove.go"} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+	}
+
+	var reports []*report.Report
+	record := WithDryRunCallback(func(r *report.Report) {
+		reports = append(reports, r)
+	})
+
+	_, err := NewWithFs("/src", []string{"/src/keep.go"}, fs, WithDryRun(true), record).Clean()
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.True(t, reports[0].DryRun)
+	assert.Equal(t, []string{"/src/keep.go"}, reports[0].Kept)
+	assert.Equal(t, []string{"/src/remove.go"}, reports[0].Removed)
+	assert.Equal(t, int64(len("test content")), reports[0].BytesReclaimed)
+
+	exists, err := afero.Exists(fs, "/src/remove.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// The callback is not called outside dry-run mode
+	_, err = NewWithFs("/src", []string{"/src/keep.go"}, fs, record).Clean()
+	require.NoError(t, err)
+	assert.Len(t, reports, 1)
+}
+
+func TestCleaner_PlanApply(t *testing.T) {
+	setup := func() afero.Fs {
+		fs := afero.NewMemMapFs()
+		for _, file := range []string{
+			"/src/go.mod",
+			"/src/.git/HEAD",
+			"/src/pkg1/keep.go",
+			"/src/pkg1/remove.go",
+			"/src/pkg2/remove.go",
+			"/src/pkg2/sub/remove.go",
+			"/src/docs/README.md",
+		} {
+			require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+		}
+		return fs
+	}
+	keep := []string{"/src/pkg1/keep.go"}
+
+	planFs := setup()
+	c := NewWithFs("/src", keep, planFs)
+	plan, err := c.Plan()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"/src/docs/README.md",
+		"/src/pkg1/remove.go",
+		"/src/pkg2/remove.go",
+		"/src/pkg2/sub/remove.go",
+	}, plan.Files)
+	assert.Equal(t, []string{"/src/docs", "/src/pkg2/sub", "/src/pkg2"}, plan.Dirs)
+	assert.Equal(t, int64(4*len("test content")), plan.BytesFreed)
+
+	// Planning changes nothing
+	before, err := c.listTree("/src")
+	require.NoError(t, err)
+	pristine, err := NewWithFs("/src", keep, setup()).listTree("/src")
+	require.NoError(t, err)
+	assert.Equal(t, pristine, before)
+
+	require.NoError(t, plan.Apply())
+
+	cleanFs := setup()
+	_, err = NewWithFs("/src", keep, cleanFs).Clean()
+	require.NoError(t, err)
+
+	applied, err := c.listTree("/src")
+	require.NoError(t, err)
+	cleaned, err := NewWithFs("/src", keep, cleanFs).listTree("/src")
+	require.NoError(t, err)
+	assert.Equal(t, cleaned, applied)
+	assert.Equal(t, []string{".git/", "go.mod", "pkg1/", "pkg1/keep.go"}, applied)
+}
+
+func TestCleaner_PlanApplyDryRun(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("test content"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/src/pkg/remove.go", []byte("test content"), 0644))
+
+	plan, err := NewWithFs("/src", []string{"/src/keep.go"}, fs, WithDryRun(true)).Plan()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/src/pkg/remove.go"}, plan.Files)
+	require.NoError(t, plan.Apply())
+
+	exists, err := afero.Exists(fs, "/src/pkg/remove.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestCleaner_CustomProtectionFunc(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, file := range []string{
+		"/src/keep.go",
+		"/src/.envrc",
+		"/src/Makefile",
+		"/src/Dockerfile",
+		"/src/Dockerfile.dev",
+		"/src/deploy/chart.yaml",
+		"/src/deploy/values.yml",
+		"/src/config/settings.yaml",
+		"/src/scripts/setup.sh",
+		"/src/notes.txt",
+	} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte("test content"), 0644))
+	}
+
+	byExtension := func(absPath string) bool {
+		return filepath.Ext(absPath) == ".yaml"
+	}
+	byNameGlob := func(absPath string) bool {
+		for _, glob := range []string{"Dockerfile*", "Makefile", ".env*"} {
+			if ok, _ := filepath.Match(glob, filepath.Base(absPath)); ok {
+				return true
+			}
+		}
+		return false
+	}
+	byDirPrefix := func(absPath string) bool {
+		return strings.HasPrefix(absPath, "/src/scripts/")
+	}
+
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs,
+		WithCustomProtectionFunc(byExtension),
+		WithCustomProtectionFunc(byNameGlob),
+		WithCustomProtectionFunc(byDirPrefix),
+	)
+	_, err := c.Clean()
+	require.NoError(t, err)
+
+	for file, want := range map[string]bool{
+		"/src/keep.go":              true,
+		"/src/.envrc":               true,
+		"/src/Makefile":             true,
+		"/src/Dockerfile":           true,
+		"/src/Dockerfile.dev":       true,
+		"/src/deploy/chart.yaml":    true,
+		"/src/deploy/values.yml":    false,
+		"/src/config/settings.yaml": true,
+		"/src/scripts/setup.sh":     true,
+		"/src/notes.txt":            false,
+	} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, file)
+	}
+}
+
+func TestCleaner_Rollback(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/src/keep.go":           "package keep",
+		"/src/remove.go":         "package remove",
+		"/src/pkg/sub/remove.go": "package sub",
+		"/src/scripts/run.sh":    "#!/bin/sh",
+	}
+	for file, content := range files {
+		require.NoError(t, afero.WriteFile(fs, file, []byte(content), 0644))
+	}
+	require.NoError(t, fs.Chmod("/src/scripts/run.sh", 0755))
+
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs, WithRollback(true))
+	summary, err := c.Clean()
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.FilesRemoved)
+	for _, dir := range []string{"/src/pkg", "/src/scripts"} {
+		exists, err := afero.Exists(fs, dir)
+		assert.NoError(t, err)
+		assert.False(t, exists, dir)
+	}
+
+	// Rolling back twice restores the same tree
+	for i := 0; i < 2; i++ {
+		require.NoError(t, c.Rollback())
+		for file, content := range files {
+			data, err := afero.ReadFile(fs, file)
+			require.NoError(t, err, file)
+			assert.Equal(t, content, string(data), file)
+		}
+		info, err := fs.Stat("/src/scripts/run.sh")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	}
+
+	// The archive survives the next clean, which replaces it
+	_, err = c.Clean()
+	require.NoError(t, err)
+	exists, err := afero.Exists(fs, "/src/"+RollbackFileName)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.False(t, c.WouldRemove("/src/"+RollbackFileName))
+}
+
+func TestCleaner_RollbackWithoutArchive(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/keep.go", []byte("test content"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("test content"), 0644))
+
+	c := NewWithFs("/src", []string{"/src/keep.go"}, fs)
+	_, err := c.Clean()
+	require.NoError(t, err)
+	assert.ErrorIs(t, c.Rollback(), ErrNoRollback)
+}
@@ -0,0 +1,29 @@
+package cleaner
+
+import (
+	"go/build"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// excludedByBuildContext reports whether the Go file at absPath is excluded
+// from the build by its file name or //go:build constraints under the default
+// build context
+func (c *Cleaner) excludedByBuildContext(absPath string) bool {
+	dir, name := filepath.Split(absPath)
+	if !strings.HasSuffix(name, ".go") || strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	ctx := build.Default
+	ctx.OpenFile = func(path string) (io.ReadCloser, error) {
+		return c.fs.Open(path)
+	}
+
+	match, err := ctx.MatchFile(dir, name)
+	if err != nil {
+		return false
+	}
+	return !match
+}
@@ -0,0 +1,35 @@
+package cleaner
+
+import (
+	"io"
+	"mime"
+	"net/http"
+)
+
+// hasPreservedContentType reports whether the content type of the file at
+// path, sniffed from its first 512 bytes, is one of c.preserveTypes
+func (c *Cleaner) hasPreservedContentType(path string) bool {
+	file, err := c.fs.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false
+	}
+
+	contentType := http.DetectContentType(buf[:n])
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	for _, t := range c.preserveTypes {
+		if t == mediaType || t == contentType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package cleaner
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastCommitTime returns the commit time of HEAD in the source directory
+func (c *Cleaner) lastCommitTime() (time.Time, error) {
+	cmd := c.commander.Command("git", "log", "-1", "--format=%at")
+	cmd.SetDir(c.sourceDir)
+	out, err := cmd.Output()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// warnNewerThanCommit logs a warning for each file modified after the last commit
+func (c *Cleaner) warnNewerThanCommit(files []string) {
+	commitTime, err := c.lastCommitTime()
+	if err != nil {
+		c.logf("Warning: failed to get last commit time, not checking for uncommitted changes: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		info, err := c.fs.Stat(file)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(commitTime) {
+			c.logf("Warning: removing %s which was modified after the last commit", file)
+		}
+	}
+}
+
+// stageRemoval stages the removal of path in the git index. Files not tracked
+// by git are ignored.
+func (c *Cleaner) stageRemoval(path string) {
+	relPath, err := filepath.Rel(c.sourceDir, path)
+	if err != nil {
+		relPath = path
+	}
+	cmd := c.commander.Command("git", "rm", "--cached", "--quiet", "--", relPath)
+	cmd.SetDir(c.sourceDir)
+	// git rm fails for untracked files, which have nothing to stage
+	_, _ = cmd.CombinedOutput()
+}
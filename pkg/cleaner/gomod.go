@@ -0,0 +1,50 @@
+package cleaner
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+	"golang.org/x/mod/modfile"
+)
+
+// goModReplace is a replace directive set with WithGoModReplace
+type goModReplace struct {
+	oldMod  string
+	newPath string
+}
+
+// updateGoModReplaces sets the replace directives requested with
+// WithGoModReplace in the go.mod file of dir. Replacement paths are relative
+// to dir and must exist.
+func (c *Cleaner) updateGoModReplaces(dir string) error {
+	goModPath := filepath.Join(dir, "go.mod")
+	data, err := afero.ReadFile(c.fs, goModPath)
+	if err != nil {
+		return err
+	}
+	f, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range c.goModReplaces {
+		target := r.newPath
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		if _, err := c.fs.Stat(target); err != nil {
+			return fmt.Errorf("replacement for %s: %v", r.oldMod, err)
+		}
+		if err := f.AddReplace(r.oldMod, "", r.newPath, ""); err != nil {
+			return err
+		}
+	}
+
+	f.Cleanup()
+	out, err := f.Format()
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(c.fs, goModPath, out, 0644)
+}
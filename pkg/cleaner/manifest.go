@@ -0,0 +1,116 @@
+package cleaner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// ManifestName is the name of the manifest written by WithManifest
+const ManifestName = "MANIFEST.txt"
+
+// writeManifest writes a manifest of the kept files to dir. Each line holds the
+// SHA-256 hash, the size in bytes and the path relative to the source directory.
+func (c *Cleaner) writeManifest(dir string, kept []string) error {
+	files := append([]string(nil), kept...)
+	sort.Strings(files)
+
+	var b strings.Builder
+	for _, file := range files {
+		sum, size, err := c.hashFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.sourceDir, file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s  %d  %s\n", sum, size, filepath.ToSlash(rel))
+	}
+
+	return afero.WriteFile(c.fs, filepath.Join(dir, ManifestName), []byte(b.String()), 0644)
+}
+
+// hashFile returns the hex-encoded SHA-256 hash and the size of a file
+func (c *Cleaner) hashFile(path string) (string, int64, error) {
+	f, err := c.fs.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// ChecksumMismatchError is returned when a kept file does not match its hash
+// in the manifest given to WithChecksumVerify
+type ChecksumMismatchError struct {
+	Path     string
+	Expected string // Empty if the file is missing from the manifest
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	if e.Expected == "" {
+		return fmt.Sprintf("checksum mismatch for %s: not in manifest", e.Path)
+	}
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// readManifest returns the hashes of a manifest written by writeManifest,
+// keyed by absolute path
+func (c *Cleaner) readManifest(path string) (map[string]string, error) {
+	data, err := afero.ReadFile(c.fs, path)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "  ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed manifest line %q", line)
+		}
+		hashes[filepath.Join(c.sourceDir, filepath.FromSlash(fields[2]))] = fields[0]
+	}
+	return hashes, nil
+}
+
+// verifyChecksums checks every file in the keep list against the manifest
+func (c *Cleaner) verifyChecksums(manifestPath string) error {
+	hashes, err := c.readManifest(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %v", err)
+	}
+
+	files := make([]string, 0, len(c.filesToKeep))
+	for file := range c.filesToKeep {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		sum, _, err := c.hashFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %v", file, err)
+		}
+		if expected := hashes[file]; expected != sum {
+			return &ChecksumMismatchError{Path: file, Expected: expected, Actual: sum}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// MultiCleaner cleans several source directories with the same options,
+// undoing every clean if any fails
+type MultiCleaner struct {
+	opts        []Option
+	cleaners    []*Cleaner
+	concurrency int
+}
+
+// NewMultiCleaner creates a MultiCleaner applying opts to every source directory
+func NewMultiCleaner(opts ...Option) *MultiCleaner {
+	return &MultiCleaner{
+		opts:        opts,
+		concurrency: 1,
+	}
+}
+
+// WithConcurrency sets how many source directories are cleaned at once
+func (m *MultiCleaner) WithConcurrency(n int) *MultiCleaner {
+	if n < 1 {
+		n = 1
+	}
+	m.concurrency = n
+	return m
+}
+
+// Add registers a source directory and the files to keep in it
+func (m *MultiCleaner) Add(sourceDir string, filesToKeep []string) *MultiCleaner {
+	m.cleaners = append(m.cleaners, New(sourceDir, filesToKeep, m.opts...))
+	return m
+}
+
+// AddWithFs registers a source directory on a custom filesystem - useful for testing
+func (m *MultiCleaner) AddWithFs(sourceDir string, filesToKeep []string, fs afero.Fs) *MultiCleaner {
+	m.cleaners = append(m.cleaners, NewWithFs(sourceDir, filesToKeep, fs, m.opts...))
+	return m
+}
+
+// CleanAll cleans every registered source directory concurrently, all or
+// nothing. The files and directories removed are held in memory until every
+// directory is clean; if any directory fails, they are put back in every
+// directory and all errors are returned together. Directories not yet started
+// when ctx is cancelled are skipped, and a cancelled clean is undone too.
+//
+// Only removals are undone: go.mod changes made by go mod tidy and files
+// copied with WithOutputDir are left as they are.
+func (m *MultiCleaner) CleanAll(ctx context.Context) error {
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, m.concurrency)
+	)
+
+	undo := make([]*undoFs, len(m.cleaners))
+	for i, c := range m.cleaners {
+		undo[i] = newUndoFs(c.fs)
+		c.fs = undo[i]
+	}
+	defer func() {
+		for i, c := range m.cleaners {
+			c.fs = undo[i].Fs
+		}
+	}()
+
+	for _, c := range m.cleaners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+
+			err := ctx.Err()
+			if err == nil {
+				_, err = c.CleanContext(ctx)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", c.sourceDir, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	for i, c := range m.cleaners {
+		if err := undo[i].restore(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to restore: %w", c.sourceDir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// undoFs records how to undo the removals and renames made through it.
+// Files it created itself, such as the writability check, are not restored.
+type undoFs struct {
+	afero.Fs
+
+	mu      sync.Mutex
+	undo    []func() error
+	created map[string]struct{}
+}
+
+func newUndoFs(fs afero.Fs) *undoFs {
+	return &undoFs{Fs: fs, created: make(map[string]struct{})}
+}
+
+func (u *undoFs) Create(name string) (afero.File, error) {
+	return u.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (u *undoFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&os.O_CREATE == 0 {
+		return u.Fs.OpenFile(name, flag, perm)
+	}
+	_, statErr := u.Fs.Stat(name)
+	f, err := u.Fs.OpenFile(name, flag, perm)
+	if err == nil && errors.Is(statErr, fs.ErrNotExist) {
+		u.mu.Lock()
+		u.created[name] = struct{}{}
+		u.mu.Unlock()
+	}
+	return f, err
+}
+
+func (u *undoFs) Remove(name string) error {
+	u.mu.Lock()
+	_, created := u.created[name]
+	u.mu.Unlock()
+	if created {
+		return u.Fs.Remove(name)
+	}
+
+	undo, err := u.saveForRestore(name)
+	if err != nil {
+		return err
+	}
+	if err := u.Fs.Remove(name); err != nil {
+		return err
+	}
+	u.push(undo)
+	return nil
+}
+
+func (u *undoFs) Rename(oldname, newname string) error {
+	if err := u.Fs.Rename(oldname, newname); err != nil {
+		return err
+	}
+	u.push(func() error {
+		if err := u.Fs.MkdirAll(filepath.Dir(oldname), 0755); err != nil {
+			return err
+		}
+		return u.Fs.Rename(newname, oldname)
+	})
+	return nil
+}
+
+func (u *undoFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if l, ok := u.Fs.(afero.Lstater); ok {
+		return l.LstatIfPossible(name)
+	}
+	info, err := u.Fs.Stat(name)
+	return info, false, err
+}
+
+func (u *undoFs) ReadlinkIfPossible(name string) (string, error) {
+	if r, ok := u.Fs.(afero.LinkReader); ok {
+		return r.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: afero.ErrNoReadlink}
+}
+
+func (u *undoFs) SymlinkIfPossible(oldname, newname string) error {
+	if l, ok := u.Fs.(afero.Linker); ok {
+		return l.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: afero.ErrNoSymlink}
+}
+
+// saveForRestore returns a function recreating name as it is now
+func (u *undoFs) saveForRestore(name string) (func() error, error) {
+	info, _, err := u.LstatIfPossible(name)
+	if err != nil {
+		return nil, err
+	}
+	mode := info.Mode()
+
+	switch {
+	case mode.IsDir():
+		return func() error {
+			return u.Fs.MkdirAll(name, mode.Perm())
+		}, nil
+
+	case mode&fs.ModeSymlink != 0:
+		target, err := u.ReadlinkIfPossible(name)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			if err := u.Fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			return u.SymlinkIfPossible(target, name)
+		}, nil
+
+	default:
+		data, err := afero.ReadFile(u.Fs, name)
+		if err != nil {
+			return nil, err
+		}
+		return func() error {
+			if err := u.Fs.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			return afero.WriteFile(u.Fs, name, data, mode.Perm())
+		}, nil
+	}
+}
+
+func (u *undoFs) push(undo func() error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.undo = append(u.undo, undo)
+}
+
+// restore undoes the recorded removals and renames, latest first
+func (u *undoFs) restore() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var errs []error
+	for i := len(u.undo) - 1; i >= 0; i-- {
+		if err := u.undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	u.undo = nil
+	return errors.Join(errs...)
+}
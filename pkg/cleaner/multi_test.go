@@ -0,0 +1,140 @@
+package cleaner
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiCleaner_CleanAll(t *testing.T) {
+	fs1 := afero.NewMemMapFs()
+	fs2 := afero.NewMemMapFs()
+	for _, file := range []string{"/mod1/keep.go", "/mod1/remove.go"} {
+		assert.NoError(t, afero.WriteFile(fs1, file, []byte("test content"), 0644))
+	}
+	for _, file := range []string{"/mod2/keep.go", "/mod2/remove.go"} {
+		assert.NoError(t, afero.WriteFile(fs2, file, []byte("test content"), 0644))
+	}
+
+	m := NewMultiCleaner().
+		WithConcurrency(2).
+		AddWithFs("/mod1", []string{"/mod1/keep.go"}, fs1).
+		AddWithFs("/mod2", []string{"/mod2/keep.go"}, fs2)
+	assert.NoError(t, m.CleanAll(context.Background()))
+
+	for fs, files := range map[afero.Fs]map[string]bool{
+		fs1: {"/mod1/keep.go": true, "/mod1/remove.go": false},
+		fs2: {"/mod2/keep.go": true, "/mod2/remove.go": false},
+	} {
+		for file, shouldExist := range files {
+			exists, err := afero.Exists(fs, file)
+			assert.NoError(t, err)
+			assert.Equal(t, shouldExist, exists, "File %s existence state is incorrect", file)
+		}
+	}
+}
+
+func TestMultiCleaner_SharedOptions(t *testing.T) {
+	fs1 := afero.NewMemMapFs()
+	fs2 := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs1, "/mod1/remove.go", []byte("test content"), 0644))
+	assert.NoError(t, afero.WriteFile(fs2, "/mod2/remove.go", []byte("test content"), 0644))
+
+	m := NewMultiCleaner(WithDryRun(true), WithAllowEmptyKeepList(true)).
+		AddWithFs("/mod1", nil, fs1).
+		AddWithFs("/mod2", nil, fs2)
+	assert.NoError(t, m.CleanAll(context.Background()))
+
+	for fs, file := range map[afero.Fs]string{fs1: "/mod1/remove.go", fs2: "/mod2/remove.go"} {
+		exists, err := afero.Exists(fs, file)
+		assert.NoError(t, err)
+		assert.True(t, exists, "File %s should not be removed in dry-run", file)
+	}
+}
+
+func TestMultiCleaner_CollectsErrors(t *testing.T) {
+	good := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(good, "/good/remove.go", []byte("test content"), 0644))
+	bad := afero.NewReadOnlyFs(afero.NewMemMapFs())
+
+	m := NewMultiCleaner(WithAllowEmptyKeepList(true)).
+		AddWithFs("/bad", nil, bad).
+		AddWithFs("/good", nil, good)
+	err := m.CleanAll(context.Background())
+	assert.ErrorIs(t, err, ErrReadOnlyFilesystem)
+
+	// The other directory is cleaned, then restored
+	content, err := afero.ReadFile(good, "/good/remove.go")
+	assert.NoError(t, err)
+	assert.Equal(t, "test content", string(content))
+}
+
+// failingRemoveFs fails removals of the given paths
+type failingRemoveFs struct {
+	afero.Fs
+	fail map[string]bool
+}
+
+func (f *failingRemoveFs) Remove(name string) error {
+	if f.fail[name] {
+		return os.ErrPermission
+	}
+	return f.Fs.Remove(name)
+}
+
+func TestMultiCleaner_RestoresOnPartialFailure(t *testing.T) {
+	fs1 := afero.NewMemMapFs()
+	for _, file := range []string{"/mod1/keep.go", "/mod1/a.go", "/mod1/sub/b.go"} {
+		assert.NoError(t, afero.WriteFile(fs1, file, []byte("content of "+file), 0644))
+	}
+	base := afero.NewMemMapFs()
+	for _, file := range []string{"/mod2/keep.go", "/mod2/a.go", "/mod2/locked.go"} {
+		assert.NoError(t, afero.WriteFile(base, file, []byte("content of "+file), 0644))
+	}
+	fs2 := &failingRemoveFs{Fs: base, fail: map[string]bool{"/mod2/locked.go": true}}
+
+	m := NewMultiCleaner().
+		AddWithFs("/mod1", []string{"/mod1/keep.go"}, fs1).
+		AddWithFs("/mod2", []string{"/mod2/keep.go"}, fs2)
+	err := m.CleanAll(context.Background())
+	assert.ErrorContains(t, err, "/mod2")
+
+	// Both directories are back as they were, including what mod2 removed
+	// before failing, and nothing else is left behind
+	for fs, files := range map[afero.Fs][]string{
+		fs1: {"/mod1/keep.go", "/mod1/a.go", "/mod1/sub/b.go"},
+		fs2: {"/mod2/keep.go", "/mod2/a.go", "/mod2/locked.go"},
+	} {
+		var found []string
+		assert.NoError(t, afero.Walk(fs, "/", func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				found = append(found, path)
+			}
+			return err
+		}))
+		assert.ElementsMatch(t, files, found)
+		for _, file := range files {
+			content, err := afero.ReadFile(fs, file)
+			assert.NoError(t, err)
+			assert.Equal(t, "content of "+file, string(content))
+		}
+	}
+}
+
+func TestMultiCleaner_Cancelled(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	assert.NoError(t, afero.WriteFile(fs, "/src/remove.go", []byte("test content"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := NewMultiCleaner().AddWithFs("/src", nil, fs).CleanAll(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	exists, err := afero.Exists(fs, "/src/remove.go")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
@@ -0,0 +1,121 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/afero"
+)
+
+// copyToOutput copies the kept files to the output directory, preserving their
+// path relative to the source directory. In mirror mode, dirs are recreated
+// (even if they end up empty) and permissions are copied from the originals.
+func (c *Cleaner) copyToOutput(kept []string, dirs []string) error {
+	for _, dir := range dirs {
+		info, err := c.fs.Stat(dir)
+		if err != nil {
+			return err
+		}
+		target, err := c.outputPath(dir)
+		if err != nil {
+			return err
+		}
+		if err := c.fs.MkdirAll(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := c.fs.Chmod(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range kept {
+		target, err := c.outputPath(file)
+		if err != nil {
+			return err
+		}
+		if err := c.copyFile(file, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cleaner) copyFile(src, dst string) error {
+	data, err := afero.ReadFile(c.fs, src)
+	if err != nil {
+		return err
+	}
+	if err := c.fs.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	if !c.mirror {
+		return afero.WriteFile(c.fs, dst, data, 0644)
+	}
+
+	info, err := c.fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := afero.WriteFile(c.fs, dst, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	// Chmod explicitly so the mode is not subject to the umask
+	return c.fs.Chmod(dst, info.Mode().Perm())
+}
+
+// dedupHardLinks replaces identical copies of kept files in the output
+// directory with hard links to a single copy. Files are only linked if both
+// their content and permissions match. Hard links require the OS filesystem.
+func (c *Cleaner) dedupHardLinks(kept []string) error {
+	if _, ok := c.fs.(*afero.OsFs); !ok {
+		c.logf("Warning: hard-link dedup requires the OS filesystem, skipping")
+		return nil
+	}
+
+	files := append([]string(nil), kept...)
+	sort.Strings(files)
+
+	first := make(map[string]string)
+	for _, file := range files {
+		target, err := c.outputPath(file)
+		if err != nil {
+			return err
+		}
+		info, err := c.fs.Stat(target)
+		if err != nil {
+			return err
+		}
+		sum, _, err := c.hashFile(target)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%s-%o", sum, info.Mode().Perm())
+		orig, ok := first[key]
+		if !ok {
+			first[key] = target
+			continue
+		}
+
+		if err := c.fs.Remove(target); err != nil {
+			return err
+		}
+		if err := os.Link(orig, target); err != nil {
+			return err
+		}
+		c.logf("  Linked %s to %s", target, orig)
+	}
+	return nil
+}
+
+// outputPath maps a path in the source directory to the output directory
+func (c *Cleaner) outputPath(path string) (string, error) {
+	rel, err := filepath.Rel(c.sourceDir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.outputDir, rel), nil
+}
@@ -0,0 +1,26 @@
+package cleaner
+
+import (
+	"io/fs"
+	"os"
+)
+
+// WithOwnershipCheck enables or disables skipping files that are not owned by
+// the current user, which could not be removed on a shared filesystem anyway.
+// Skipped files are kept and logged. The check only applies on Unix and to
+// filesystems that report file owners, such as the OS filesystem.
+func WithOwnershipCheck(check bool) Option {
+	return func(c *Cleaner) {
+		c.checkOwner = check
+	}
+}
+
+// ownedByOther reports whether the file described by info is owned by another
+// user than the current one, returning the owner's uid
+func ownedByOther(info fs.FileInfo) (int, bool) {
+	uid, ok := fileOwner(info)
+	if !ok {
+		return 0, false
+	}
+	return uid, uid != os.Getuid()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package cleaner
+
+import "io/fs"
+
+// fileOwner returns the uid of the owner of the file described by info. File
+// ownership is not checked outside Unix.
+func fileOwner(info fs.FileInfo) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,83 @@
+//go:build unix
+
+package cleaner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ownerFs reports the files in others as owned by another user and every other
+// file as owned by the current user
+type ownerFs struct {
+	afero.Fs
+	others map[string]bool
+}
+
+func (f *ownerFs) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.Fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	uid := uint32(os.Getuid())
+	if f.others[name] {
+		uid++
+	}
+	return ownedInfo{FileInfo: info, stat: &syscall.Stat_t{Uid: uid}}, nil
+}
+
+type ownedInfo struct {
+	fs.FileInfo
+	stat *syscall.Stat_t
+}
+
+func (i ownedInfo) Sys() any { return i.stat }
+
+func TestCleaner_OwnershipCheck(t *testing.T) {
+	newFs := func(t *testing.T) *ownerFs {
+		base := afero.NewMemMapFs()
+		for _, file := range []string{"/src/keep.go", "/src/mine.go", "/src/theirs.go", "/src/pkg/theirs.go"} {
+			require.NoError(t, afero.WriteFile(base, file, []byte("test content"), 0644))
+		}
+		return &ownerFs{Fs: base, others: map[string]bool{"/src/theirs.go": true, "/src/pkg/theirs.go": true}}
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		fs := newFs(t)
+		logger := &recordingLogger{}
+		c := NewWithFs("/src", []string{"/src/keep.go"}, fs, WithOwnershipCheck(true), WithLogger(logger))
+		assert.False(t, c.WouldRemove("/src/theirs.go"))
+		assert.True(t, c.WouldRemove("/src/mine.go"))
+
+		summary, err := c.Clean()
+		require.NoError(t, err)
+		assert.Equal(t, 1, summary.FilesRemoved)
+		assert.Equal(t, []string{"/src/mine.go"}, c.Report().Removed)
+
+		uid := os.Getuid() + 1
+		assert.ElementsMatch(t, []string{
+			fmt.Sprintf("Warning: skipping /src/theirs.go owned by uid %d", uid),
+			fmt.Sprintf("Warning: skipping /src/pkg/theirs.go owned by uid %d", uid),
+		}, logger.messages)
+		for _, file := range []string{"/src/theirs.go", "/src/pkg/theirs.go"} {
+			exists, err := afero.Exists(fs, file)
+			require.NoError(t, err)
+			assert.True(t, exists, file)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		fs := newFs(t)
+		c := NewWithFs("/src", []string{"/src/keep.go"}, fs)
+		summary, err := c.Clean()
+		require.NoError(t, err)
+		assert.Equal(t, 3, summary.FilesRemoved)
+	})
+}
@@ -0,0 +1,89 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sigma/monorepo-hatchet/pkg/report"
+	"github.com/spf13/afero"
+)
+
+// CleanPlan lists what a clean of the source directory would remove. It is
+// computed by Cleaner.Plan and carried out by Apply.
+type CleanPlan struct {
+	Files      []string // Files to remove
+	Dirs       []string // Directories left empty by removing Files, deepest first
+	Kept       []string // Files kept
+	BytesFreed int64    // Total size of Files
+
+	cleaner *Cleaner
+}
+
+// Plan walks the source directory and computes what Clean would remove,
+// without changing anything. Files that cannot be read are left out of the
+// plan and reported in the returned error, along with the plan itself.
+// go mod tidy, vet and the other post-clean steps are not part of the plan.
+func (c *Cleaner) Plan() (*CleanPlan, error) {
+	if len(c.filesToKeep) == 0 && !c.allowEmptyKeep {
+		return nil, ErrEmptyKeepList
+	}
+	if c.outputDir != "" {
+		return nil, errors.New("cannot plan a clean to an output directory")
+	}
+
+	rep := &report.Report{DryRun: true, RemovedPackages: c.removedPkgs}
+	toRemove, _, errs, err := c.scan(context.Background(), time.Now(), rep)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CleanPlan{
+		Files:      toRemove,
+		Kept:       rep.Kept,
+		BytesFreed: rep.BytesReclaimed,
+		cleaner:    c,
+	}
+	if !c.preserveDirs {
+		removed := make(map[string]struct{}, len(toRemove))
+		for _, path := range toRemove {
+			removed[path] = struct{}{}
+		}
+		if plan.Dirs, _, err = c.removeEmptyDirs(c.sourceDir, removed, true); err != nil {
+			return nil, fmt.Errorf("failed to find empty directories: %v", err)
+		}
+	}
+	return plan, errors.Join(errs...)
+}
+
+// Apply removes the files and then the directories of the plan. Directories
+// that are no longer empty are left alone. In dry-run mode nothing is removed.
+func (p *CleanPlan) Apply() error {
+	c := p.cleaner
+	if c.dryRun {
+		return nil
+	}
+	if err := c.checkWritable(); err != nil {
+		return err
+	}
+
+	var errs []error
+	if _, err := c.removeFiles(context.Background(), p.Files); err != nil {
+		errs = append(errs, err)
+	}
+	for _, dir := range p.Dirs {
+		entries, err := afero.ReadDir(c.fs, dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read %s: %w", dir, err))
+			continue
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err := c.fs.Remove(dir); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %s: %w", dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,174 @@
+package cleaner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// RollbackFileName is the archive written at the root of the source directory
+// by WithRollback, holding the files removed by the last clean. It is always
+// kept.
+const RollbackFileName = ".hatchet-rollback.tar.gz"
+
+// rollbackManifestName is the first entry of the rollback archive, listing the
+// files it holds
+const rollbackManifestName = "rollback.json"
+
+// ErrNoRollback is returned by Rollback when there is no rollback archive
+var ErrNoRollback = errors.New("no rollback archive")
+
+type rollbackEntry struct {
+	Path   string      `json:"path"` // Relative to the source directory, with forward slashes
+	SHA256 string      `json:"sha256"`
+	Mode   fs.FileMode `json:"mode"`
+}
+
+// WithRollback enables or disables saving the files to remove to
+// RollbackFileName before removing them, so that Rollback can restore them
+func WithRollback(enabled bool) Option {
+	return func(c *Cleaner) {
+		c.rollback = enabled
+	}
+}
+
+// writeRollback saves files, with a manifest of their hashes, to the rollback
+// archive, replacing the archive of any previous clean
+func (c *Cleaner) writeRollback(files []string) error {
+	manifest := make([]rollbackEntry, 0, len(files))
+	infos := make([]fs.FileInfo, 0, len(files))
+	for _, file := range files {
+		info, err := c.fs.Stat(file)
+		if err != nil {
+			return err
+		}
+		hash, _, err := c.hashFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.sourceDir, file)
+		if err != nil {
+			return err
+		}
+		manifest = append(manifest, rollbackEntry{Path: filepath.ToSlash(rel), SHA256: hash, Mode: info.Mode().Perm()})
+		infos = append(infos, info)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := c.fs.Create(filepath.Join(c.sourceDir, RollbackFileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	if err := tw.WriteHeader(&tar.Header{Name: rollbackManifestName, Mode: 0644, Size: int64(len(data))}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	for i, file := range files {
+		hdr, err := tar.FileInfoHeader(infos[i], "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = manifest[i].Path
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if err := c.copyEntry(tw, file); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Rollback restores the files removed by the last clean run with
+// WithRollback, from the rollback archive in the source directory. Files that
+// are already present with the saved content are left alone, so calling it
+// again is harmless. It returns ErrNoRollback if there is no archive.
+func (c *Cleaner) Rollback() error {
+	f, err := c.fs.Open(filepath.Join(c.sourceDir, RollbackFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNoRollback
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid rollback archive: %v", err)
+	}
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != rollbackManifestName {
+		return fmt.Errorf("invalid rollback archive: missing %s", rollbackManifestName)
+	}
+	var manifest []rollbackEntry
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return fmt.Errorf("invalid rollback archive: %v", err)
+	}
+	expected := make(map[string]rollbackEntry, len(manifest))
+	for _, entry := range manifest {
+		expected[entry.Path] = entry
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid rollback archive: %v", err)
+		}
+		entry, ok := expected[hdr.Name]
+		if !ok || strings.HasPrefix(hdr.Name, "../") || filepath.IsAbs(hdr.Name) {
+			return fmt.Errorf("invalid rollback archive: unexpected entry %s", hdr.Name)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		if hex.EncodeToString(hash[:]) != entry.SHA256 {
+			return fmt.Errorf("invalid rollback archive: checksum mismatch for %s", hdr.Name)
+		}
+
+		target := filepath.Join(c.sourceDir, filepath.FromSlash(hdr.Name))
+		if current, err := afero.ReadFile(c.fs, target); err == nil && bytes.Equal(current, data) {
+			continue
+		}
+		if err := c.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := afero.WriteFile(c.fs, target, data, entry.Mode); err != nil {
+			return err
+		}
+	}
+}
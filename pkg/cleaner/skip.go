@@ -0,0 +1,36 @@
+package cleaner
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SkipFileName is the file at the root of the source directory listing
+// directory names, one per line, that are skipped entirely during cleaning
+const SkipFileName = ".hatchetskip"
+
+// readSkipFile returns the directory names listed in the skip file. Blank
+// lines and lines starting with # are ignored.
+func (c *Cleaner) readSkipFile() (map[string]struct{}, error) {
+	file, err := c.fs.Open(filepath.Join(c.sourceDir, SkipFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	skip := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		skip[strings.TrimSuffix(line, "/")] = struct{}{}
+	}
+	return skip, scanner.Err()
+}
@@ -0,0 +1,61 @@
+package cleaner
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// WithSymlinkFollowing enables or disables walking into symlinked directories.
+// It is disabled by default: symlinks are then kept or removed like files,
+// removing a symlink never touches its target, and nothing outside the source
+// directory is visited through one. A symlink to a directory is kept if any
+// kept file lies below it.
+//
+// When enabled, the files below a symlinked directory are cleaned as if they
+// were in the source directory, which removes them from the target directory.
+// Following needs a filesystem that can read symlinks, such as the OS
+// filesystem.
+func WithSymlinkFollowing(follow bool) Option {
+	return func(c *Cleaner) {
+		c.followSymlinks = follow
+	}
+}
+
+// isSymlink reports whether info describes a symlink
+func isSymlink(info fs.FileInfo) bool {
+	return info.Mode()&fs.ModeSymlink != 0
+}
+
+// symlinkedDir returns the cleaned absolute target of the symlink at path if
+// it points to a directory
+func (c *Cleaner) symlinkedDir(path string) (string, bool) {
+	reader, ok := c.fs.(afero.LinkReader)
+	if !ok {
+		return "", false
+	}
+	target, err := reader.ReadlinkIfPossible(path)
+	if err != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	if info, err := c.fs.Stat(target); err != nil || !info.IsDir() {
+		return "", false
+	}
+	return filepath.Clean(target), true
+}
+
+// keepsBelow reports whether any kept file lies below the directory dir
+func (c *Cleaner) keepsBelow(dir string) bool {
+	prefix := dir + string(filepath.Separator)
+	for file := range c.filesToKeep {
+		if strings.HasPrefix(file, prefix) {
+			return true
+		}
+	}
+	return false
+}
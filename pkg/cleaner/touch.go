@@ -0,0 +1,43 @@
+package cleaner
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// WithTouchKeptFiles enables or disables setting the access and modification
+// times of every kept file to the current time after the removal pass, for
+// build systems that would otherwise consider them stale
+func WithTouchKeptFiles(touch bool) Option {
+	return func(c *Cleaner) {
+		c.touchKept = touch
+	}
+}
+
+// touchKeptFiles sets the times of the files in the keep list to now. Files in
+// the keep list that do not exist are ignored. In dry-run mode the files are
+// only logged.
+func (c *Cleaner) touchKeptFiles(now time.Time) error {
+	files := make([]string, 0, len(c.filesToKeep))
+	for file := range c.filesToKeep {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if c.dryRun {
+			if _, err := c.fs.Stat(file); err == nil {
+				c.logf("Dry run: would touch %s", file)
+			}
+			continue
+		}
+		if err := c.fs.Chtimes(file, now, now); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package cleaner
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// listTree returns the entries below root, relative to it and with directories
+// ending in "/". The contents of .git are not listed.
+func (c *Cleaner) listTree(root string) ([]string, error) {
+	var entries []string
+	err := afero.Walk(c.fs, root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			entries = append(entries, rel+"/")
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries = append(entries, rel)
+		return nil
+	})
+	return entries, err
+}
+
+// predictTree returns the tree listed in before once the files in removed are
+// gone, along with the directories the clean leaves empty unless they are
+// preserved. It stands for the tree after a dry run.
+func (c *Cleaner) predictTree(before []string, removed []string) []string {
+	gone := make(map[string]struct{}, len(removed))
+	for _, path := range removed {
+		if rel, err := filepath.Rel(c.sourceDir, path); err == nil {
+			gone[filepath.ToSlash(rel)] = struct{}{}
+		}
+	}
+
+	// Protected .git directories are never removed, so count them as files
+	var files []string
+	for _, entry := range before {
+		if _, ok := gone[entry]; ok {
+			continue
+		}
+		if !strings.HasSuffix(entry, "/") || (c.protectGit && path.Base(entry) == ".git") {
+			files = append(files, entry)
+		}
+	}
+
+	var after []string
+	for _, entry := range before {
+		if _, ok := gone[entry]; ok {
+			continue
+		}
+		if strings.HasSuffix(entry, "/") && !c.preserveDirs && !hasFileBelow(files, entry) {
+			continue
+		}
+		after = append(after, entry)
+	}
+	return after
+}
+
+func hasFileBelow(files []string, dir string) bool {
+	for _, file := range files {
+		if strings.HasPrefix(file, dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package cleaner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sigma/monorepo-hatchet/pkg/pkglist"
+	"github.com/spf13/afero"
+)
+
+// BuildVerifyError is returned when the source tree does not build with the
+// files to remove hidden
+type BuildVerifyError struct {
+	Output string
+}
+
+func (e *BuildVerifyError) Error() string {
+	return fmt.Sprintf("build verification failed:\n%s", e.Output)
+}
+
+// buildOverlay returns a go build -overlay file hiding files. An empty
+// replacement path makes the go command treat the file as deleted.
+func buildOverlay(files []string) ([]byte, error) {
+	overlay := struct {
+		Replace map[string]string
+	}{Replace: make(map[string]string, len(files))}
+	for _, file := range files {
+		overlay.Replace[file] = ""
+	}
+	return json.Marshal(overlay)
+}
+
+// verifyBuild runs go build ./... in the source directory as if files had
+// already been removed
+func (c *Cleaner) verifyBuild(ctx context.Context, files []string) error {
+	data, err := buildOverlay(files)
+	if err != nil {
+		return err
+	}
+
+	overlay, err := afero.TempFile(c.fs, "", "hatchet-overlay-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create overlay: %v", err)
+	}
+	defer c.fs.Remove(overlay.Name())
+	if _, err := overlay.Write(data); err != nil {
+		overlay.Close()
+		return fmt.Errorf("failed to write overlay: %v", err)
+	}
+	if err := overlay.Close(); err != nil {
+		return fmt.Errorf("failed to write overlay: %v", err)
+	}
+
+	cmd := pkglist.CommandWithContext(ctx, c.commander, "go", "build", "-overlay", overlay.Name(), "./...")
+	cmd.SetDir(c.sourceDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return &BuildVerifyError{Output: string(out)}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package pkglist
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// WithIncludePrebuiltBinaries enables or disables keeping the executable files
+// found in bin directories of the source tree (e.g. bin/ or tools/bin/), which
+// go list knows nothing about
+func WithIncludePrebuiltBinaries(enabled bool) Option {
+	return func(f *Finder) {
+		f.prebuiltBinaries = enabled
+	}
+}
+
+// findPrebuiltBinaries returns the files with any execute permission bit set
+// inside a directory named bin below the source directory
+func (f *Finder) findPrebuiltBinaries() []string {
+	var files []string
+	err := afero.Walk(f.fs, f.sourceDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 && filepath.Base(filepath.Dir(path)) == "bin" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		f.logf("  Failed to scan for prebuilt binaries in %s: %v", f.sourceDir, err)
+	}
+	return files
+}
@@ -0,0 +1,57 @@
+package pkglist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// WriteDOT writes the dependency graph of the kept packages to w in Graphviz
+// DOT format, with an edge from each package to each of its Deps. If
+// keepPackages is nil, every package found is included. Dependencies outside
+// the repository are drawn dashed.
+func (f *Finder) WriteDOT(w io.Writer, keepPackages map[string]struct{}) error {
+	var nodes []string
+	for path := range f.packages {
+		if _, keep := keepPackages[path]; keep || keepPackages == nil {
+			nodes = append(nodes, path)
+		}
+	}
+	sort.Strings(nodes)
+
+	included := make(map[string]struct{}, len(nodes))
+	for _, path := range nodes {
+		included[path] = struct{}{}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph packages {")
+	for _, path := range nodes {
+		fmt.Fprintf(bw, "\t%s;\n", strconv.Quote(path))
+	}
+
+	external := make(map[string]struct{})
+	for _, path := range nodes {
+		deps := append([]string(nil), f.packages[path].Deps...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := included[dep]; ok {
+				fmt.Fprintf(bw, "\t%s -> %s;\n", strconv.Quote(path), strconv.Quote(dep))
+				continue
+			}
+			if _, inRepo := f.packages[dep]; inRepo {
+				continue
+			}
+			if _, ok := external[dep]; !ok {
+				external[dep] = struct{}{}
+				fmt.Fprintf(bw, "\t%s [style=dashed];\n", strconv.Quote(dep))
+			}
+			fmt.Fprintf(bw, "\t%s -> %s [style=dashed];\n", strconv.Quote(path), strconv.Quote(dep))
+		}
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
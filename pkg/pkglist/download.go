@@ -0,0 +1,61 @@
+package pkglist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DownloadError is returned when go mod download fails
+type DownloadError struct {
+	// Modules lists the modules that failed to download with their error
+	Modules []string
+	Err     error
+}
+
+func (e *DownloadError) Error() string {
+	if len(e.Modules) == 0 {
+		return fmt.Sprintf("failed to download modules: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to download modules: %v\n%s", e.Err, strings.Join(e.Modules, "\n"))
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// downloadModules runs go mod download so that go list does not fail on an
+// empty module cache
+func (f *Finder) downloadModules(ctx context.Context, env []string) error {
+	cmd := CommandWithContext(ctx, f.commander, "go", "mod", "download", "-json")
+	cmd.SetDir(f.sourceDir)
+	cmd.SetEnv(env)
+
+	out, err := cmd.Output()
+	if err == nil {
+		f.logf("Downloaded modules for %s", f.sourceDir)
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	// go mod download -json reports per-module failures in the Error field
+	dlErr := &DownloadError{Err: err}
+	decoder := json.NewDecoder(strings.NewReader(string(out)))
+	for decoder.More() {
+		var mod struct {
+			Path    string
+			Version string
+			Error   string
+		}
+		if decoder.Decode(&mod) != nil {
+			break
+		}
+		if mod.Error != "" {
+			dlErr.Modules = append(dlErr.Modules, fmt.Sprintf("%s@%s: %s", mod.Path, mod.Version, mod.Error))
+		}
+	}
+	return dlErr
+}
@@ -0,0 +1,53 @@
+package pkglist
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// WithDeepEmbedScan enables or disables keeping the whole tree of every
+// directory embedded with //go:embed, rather than only the files the embed
+// patterns select. Files that go:embed skips, such as those starting with "."
+// or "_", may still be read at runtime through an embed.FS by code using the
+// directory.
+func WithDeepEmbedScan(enabled bool) Option {
+	return func(f *Finder) {
+		f.deepEmbedScan = enabled
+	}
+}
+
+// embeddedDirFiles returns every file below the directories matched by the
+// embed patterns of pkg
+func (f *Finder) embeddedDirFiles(pkg *Package) []string {
+	var files []string
+	for _, pattern := range pkg.EmbedPatterns {
+		pattern = strings.TrimPrefix(pattern, "all:")
+		matches, err := afero.Glob(f.fs, filepath.Join(pkg.Dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			f.logf("  Failed to expand embed pattern %s in %s: %v", pattern, pkg.Dir, err)
+			continue
+		}
+
+		for _, match := range matches {
+			if isDir, err := afero.IsDir(f.fs, match); err != nil || !isDir {
+				continue
+			}
+			err := afero.Walk(f.fs, match, func(path string, info fs.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				f.logf("  Failed to scan embedded directory %s: %v", match, err)
+			}
+		}
+	}
+	return files
+}
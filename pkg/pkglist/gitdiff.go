@@ -0,0 +1,47 @@
+package pkglist
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PackagesFromGitDiff returns the packages containing the files changed in
+// the git revision range rangeSpec (e.g. HEAD~3..HEAD). Each file is assigned
+// to the package with the deepest directory containing it.
+func (f *Finder) PackagesFromGitDiff(rangeSpec string) (PkgSet, error) {
+	cmd := f.commander.Command("git", "diff", "--name-only", "--relative", rangeSpec)
+	cmd.SetDir(f.sourceDir)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run git diff: %v", err)
+	}
+
+	byDir := f.PackagesByDir()
+	pkgs := make(PkgSet)
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		file := strings.TrimSpace(scanner.Text())
+		if file == "" {
+			continue
+		}
+
+		// Walk up from the file's directory to the closest package directory
+		dir := filepath.Dir(filepath.Join(f.sourceDir, filepath.FromSlash(file)))
+		for f.underSourceDir(dir) {
+			if dirPkgs, ok := byDir[dir]; ok {
+				for _, pkg := range dirPkgs {
+					pkgs[pkg.ImportPath] = struct{}{}
+				}
+				break
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return pkgs, scanner.Err()
+}
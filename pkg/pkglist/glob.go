@@ -0,0 +1,87 @@
+package pkglist
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FilterByGlobs returns packages whose directory, relative to the source
+// directory, matches any of the given globs. In addition to the path.Match
+// syntax, a "**" segment matches zero or more directories.
+func (f *Finder) FilterByGlobs(patterns []string) map[string]struct{} {
+	keepPackages := make(map[string]struct{})
+	for _, pattern := range patterns {
+		f.logf("Processing glob: %s", pattern)
+		for _, pkg := range f.packages {
+			relDir, err := filepath.Rel(f.sourceDir, pkg.Dir)
+			if err != nil {
+				continue
+			}
+			if matchGlob(pattern, filepath.ToSlash(relDir)) {
+				f.logf("  Matched package: %s at %s", pkg.ImportPath, pkg.Dir)
+				keepPackages[pkg.ImportPath] = struct{}{}
+				f.markMatched(pkg.ImportPath, fmt.Sprintf("matched glob '%s'", pattern))
+			}
+		}
+	}
+	return keepPackages
+}
+
+// FilterByDirectory returns packages whose directory is one of dirs or lies
+// below one of them. Relative directories are resolved against the source
+// directory. Empty entries are ignored rather than matching the whole source
+// directory; use "." for that.
+func (f *Finder) FilterByDirectory(dirs []string) map[string]struct{} {
+	keepPackages := make(map[string]struct{})
+	for _, dir := range dirs {
+		if strings.TrimSpace(dir) == "" {
+			continue
+		}
+		f.logf("Processing directory: %s", dir)
+		absDir := dir
+		if !filepath.IsAbs(absDir) {
+			absDir = filepath.Join(f.sourceDir, absDir)
+		}
+		absDir = filepath.Clean(absDir)
+
+		for _, pkg := range f.packages {
+			rel, err := filepath.Rel(absDir, pkg.Dir)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			f.logf("  Matched package: %s at %s", pkg.ImportPath, pkg.Dir)
+			keepPackages[pkg.ImportPath] = struct{}{}
+			f.markMatched(pkg.ImportPath, fmt.Sprintf("matched directory '%s'", dir))
+		}
+	}
+	return keepPackages
+}
+
+// matchGlob reports whether the slash-separated name matches pattern
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,67 @@
+package pkglist
+
+import (
+	"go/version"
+
+	"github.com/spf13/afero"
+	"golang.org/x/mod/modfile"
+)
+
+// WithMinGoVersion excludes from FilterByPatterns any package whose module
+// requires a newer Go version than goVersion (e.g. "1.20"), as set by the go
+// directive of its go.mod
+func WithMinGoVersion(goVersion string) Option {
+	return func(f *Finder) {
+		f.minGoVersion = goVersion
+	}
+}
+
+// ValidGoVersion reports whether v is a Go version such as "1.21" or "1.21.3"
+func ValidGoVersion(v string) bool {
+	return version.IsValid("go" + v)
+}
+
+// requiresNewerGo reports whether pkg requires a newer Go version than the one
+// set with WithMinGoVersion
+func (f *Finder) requiresNewerGo(pkg *Package) (string, bool) {
+	if f.minGoVersion == "" {
+		return "", false
+	}
+	goVersion := f.goVersionOf(pkg)
+	if goVersion == "" {
+		return "", false
+	}
+	return goVersion, version.Compare("go"+goVersion, "go"+f.minGoVersion) > 0
+}
+
+// goVersionOf returns the Go version required by the module of pkg, or "" if
+// it is unknown
+func (f *Finder) goVersionOf(pkg *Package) string {
+	if pkg.Module != nil && pkg.Module.GoVersion != "" {
+		return pkg.Module.GoVersion
+	}
+	if pkg.GoMod == "" {
+		return ""
+	}
+
+	if goVersion, ok := f.goModVersions[pkg.GoMod]; ok {
+		return goVersion
+	}
+	var goVersion string
+	data, err := afero.ReadFile(f.fs, pkg.GoMod)
+	if err == nil {
+		var mf *modfile.File
+		if mf, err = modfile.ParseLax(pkg.GoMod, data, nil); err == nil && mf.Go != nil {
+			goVersion = mf.Go.Version
+		}
+	}
+	if err != nil {
+		f.logf("Warning: failed to read Go version from %s: %v", pkg.GoMod, err)
+	}
+
+	if f.goModVersions == nil {
+		f.goModVersions = make(map[string]string)
+	}
+	f.goModVersions[pkg.GoMod] = goVersion
+	return goVersion
+}
@@ -0,0 +1,376 @@
+package pkglist
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// CircularDepError is returned when the dependency graph contains a cycle
+type CircularDepError struct {
+	// Cycle lists the import paths forming the cycle, in dependency order
+	Cycle []string
+}
+
+func (e *CircularDepError) Error() string {
+	if len(e.Cycle) == 0 {
+		return "circular dependency"
+	}
+	return "circular dependency: " + strings.Join(e.Cycle, " -> ") + " -> " + e.Cycle[0]
+}
+
+// TopologicalSort orders the kept packages so that every package comes after
+// its in-repo dependencies. It returns a *CircularDepError if the kept
+// packages depend on each other in a cycle.
+func (f *Finder) TopologicalSort(keepPackages map[string]struct{}) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	roots := make([]string, 0, len(keepPackages))
+	for pkg := range keepPackages {
+		roots = append(roots, pkg)
+	}
+	sort.Strings(roots)
+
+	state := make(map[string]int)
+	var (
+		order []string
+		stack []string
+	)
+
+	var visit func(pkg string) error
+	visit = func(pkg string) error {
+		switch state[pkg] {
+		case visited:
+			return nil
+		case visiting:
+			for i, p := range stack {
+				if p == pkg {
+					return &CircularDepError{Cycle: append([]string(nil), stack[i:]...)}
+				}
+			}
+		}
+
+		state[pkg] = visiting
+		stack = append(stack, pkg)
+		if p, ok := f.packages[pkg]; ok {
+			for _, dep := range p.Deps {
+				if _, keep := keepPackages[dep]; !keep {
+					continue
+				}
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[pkg] = visited
+
+		order = append(order, pkg)
+		return nil
+	}
+
+	for _, pkg := range roots {
+		if err := visit(pkg); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// FindCycles returns the cycles in the dependency graph of the packages found,
+// each as the import paths forming it in dependency order. A depth-first
+// search reports one cycle per edge leading back to a package being visited,
+// so a package may appear in several cycles.
+func (f *Finder) FindCycles() [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	roots := make([]string, 0, len(f.packages))
+	for pkg := range f.packages {
+		roots = append(roots, pkg)
+	}
+	sort.Strings(roots)
+
+	state := make(map[string]int)
+	var (
+		cycles [][]string
+		stack  []string
+	)
+
+	var visit func(pkg string)
+	visit = func(pkg string) {
+		state[pkg] = visiting
+		stack = append(stack, pkg)
+		for _, dep := range f.packages[pkg].Deps {
+			if _, inRepo := f.packages[dep]; !inRepo {
+				continue
+			}
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				i := slices.Index(stack, dep)
+				cycles = append(cycles, append([]string(nil), stack[i:]...))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[pkg] = visited
+	}
+
+	for _, pkg := range roots {
+		if state[pkg] == unvisited {
+			visit(pkg)
+		}
+	}
+	return cycles
+}
+
+// FindOrphans returns the packages of the keep set last passed to
+// AddDependencies that are not reachable from any package explicitly matched
+// by a pattern, sorted by import path
+func (f *Finder) FindOrphans() []*Package {
+	reachable := make(map[string]struct{})
+	toProcess := make([]string, 0, len(f.matched))
+	for pkg := range f.matched {
+		if _, keep := f.keep[pkg]; keep {
+			reachable[pkg] = struct{}{}
+			toProcess = append(toProcess, pkg)
+		}
+	}
+
+	for i := 0; i < len(toProcess); i++ {
+		p, ok := f.packages[toProcess[i]]
+		if !ok {
+			continue
+		}
+		for _, dep := range p.Deps {
+			if _, keep := f.keep[dep]; !keep {
+				continue
+			}
+			if _, seen := reachable[dep]; !seen {
+				reachable[dep] = struct{}{}
+				toProcess = append(toProcess, dep)
+			}
+		}
+	}
+
+	var orphans []*Package
+	for importPath := range f.keep {
+		if _, ok := reachable[importPath]; ok {
+			continue
+		}
+		if p, ok := f.packages[importPath]; ok {
+			orphans = append(orphans, p)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].ImportPath < orphans[j].ImportPath
+	})
+	return orphans
+}
+
+// DepthReport returns the depth of every package in keepPackages, measured as
+// the length of the shortest chain of direct imports from a package
+// explicitly matched by a pattern. Matched packages have depth 0; kept
+// packages that are not reachable from any matched package have depth -1.
+func (f *Finder) DepthReport(keepPackages PkgSet) map[string]int {
+	depths := make(map[string]int, len(keepPackages))
+	var toProcess []string
+	for pkg := range keepPackages {
+		if _, ok := f.matched[pkg]; ok {
+			depths[pkg] = 0
+			toProcess = append(toProcess, pkg)
+		}
+	}
+	sort.Strings(toProcess)
+
+	for i := 0; i < len(toProcess); i++ {
+		p, ok := f.packages[toProcess[i]]
+		if !ok {
+			continue
+		}
+		for _, dep := range p.Imports {
+			if _, keep := keepPackages[dep]; !keep {
+				continue
+			}
+			if _, seen := depths[dep]; !seen {
+				depths[dep] = depths[toProcess[i]] + 1
+				toProcess = append(toProcess, dep)
+			}
+		}
+	}
+
+	for pkg := range keepPackages {
+		if _, ok := depths[pkg]; !ok {
+			depths[pkg] = -1
+		}
+	}
+	return depths
+}
+
+// DepsOf returns the in-repo packages directly imported by importPath, sorted
+// by import path
+func (f *Finder) DepsOf(importPath string) []*Package {
+	p, ok := f.packages[importPath]
+	if !ok {
+		return nil
+	}
+	return f.lookupPackages(p.Imports)
+}
+
+// TransitiveDepsOf returns all in-repo packages reachable from importPath,
+// sorted by import path
+func (f *Finder) TransitiveDepsOf(importPath string) []*Package {
+	if _, ok := f.packages[importPath]; !ok {
+		return nil
+	}
+
+	var reachable []string
+	f.walkDeps([]string{importPath}, map[string]struct{}{importPath: {}}, func(dep, _ string) {
+		reachable = append(reachable, dep)
+	})
+	return f.lookupPackages(reachable)
+}
+
+// GetTransitiveDeps returns the sorted import paths of the repository packages
+// among roots and all the repository packages they depend on, that is the
+// keep set AddDependencies would produce from roots, without modifying roots
+func (f *Finder) GetTransitiveDeps(roots map[string]struct{}) []string {
+	seen := make(map[string]struct{}, len(roots))
+	var inRepo []string
+	for importPath := range roots {
+		if _, ok := f.packages[importPath]; ok {
+			seen[importPath] = struct{}{}
+			inRepo = append(inRepo, importPath)
+		}
+	}
+	f.walkDeps(inRepo, seen, nil)
+
+	deps := make([]string, 0, len(seen))
+	for importPath := range seen {
+		deps = append(deps, importPath)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// walkDeps follows the Deps of the repository packages breadth-first from
+// roots, skipping the internal packages excluded by
+// WithExcludeCrossModuleInternal. Every repository package reached that is not
+// yet in seen is added to it and, if add is set, passed to add along with the
+// package that depends on it.
+func (f *Finder) walkDeps(roots []string, seen map[string]struct{}, add func(dep, addedBy string)) {
+	toProcess := append([]string(nil), roots...)
+	for i := 0; i < len(toProcess); i++ {
+		pkg := toProcess[i]
+		p, ok := f.packages[pkg]
+		if !ok {
+			continue
+		}
+		for _, dep := range p.Deps {
+			if f.excludeCrossModuleInternal && !f.internalAllowed(p, dep) {
+				f.logf("  Skipping internal package %s imported by %s", dep, pkg)
+				continue
+			}
+			if _, ok := seen[dep]; ok {
+				continue
+			}
+			if _, inRepo := f.packages[dep]; inRepo {
+				seen[dep] = struct{}{}
+				toProcess = append(toProcess, dep)
+				if add != nil {
+					add(dep, pkg)
+				}
+			}
+		}
+	}
+}
+
+// FindImporters returns the sorted import paths of the repository packages
+// that import importPath directly. importPath need not be in the repository.
+func (f *Finder) FindImporters(importPath string) []string {
+	var importers []string
+	for path, p := range f.packages {
+		if slices.Contains(p.Imports, importPath) {
+			importers = append(importers, path)
+		}
+	}
+	sort.Strings(importers)
+	return importers
+}
+
+// lookupPackages returns the in-repo packages among importPaths, sorted by
+// import path
+func (f *Finder) lookupPackages(importPaths []string) []*Package {
+	var pkgs []*Package
+	for _, importPath := range importPaths {
+		if p, ok := f.packages[importPath]; ok {
+			pkgs = append(pkgs, p)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].ImportPath < pkgs[j].ImportPath
+	})
+	return pkgs
+}
+
+// Explain returns a human-readable explanation of why importPath is or is not
+// in the keep set last expanded by AddDependencies, e.g.
+//
+//	kept because: matched pattern 'op-node/...'; also required by example.com/op-node/service (direct dep)
+func (f *Finder) Explain(importPath string) string {
+	if _, ok := f.packages[importPath]; !ok {
+		return fmt.Sprintf("unknown package %s", importPath)
+	}
+
+	keep := f.keep
+	if keep == nil {
+		keep = f.matched
+	}
+	if _, kept := keep[importPath]; !kept {
+		if pattern, ok := f.removed[importPath]; ok {
+			return fmt.Sprintf("not kept: removed by pattern '%s'", pattern)
+		}
+		return "not kept: not matched by any pattern or required by any kept package"
+	}
+
+	var dependents []string
+	for _, p := range f.Packages() {
+		if p.ImportPath == importPath {
+			continue
+		}
+		if _, kept := keep[p.ImportPath]; !kept {
+			continue
+		}
+		switch {
+		case slices.Contains(p.Imports, importPath):
+			dependents = append(dependents, p.ImportPath+" (direct dep)")
+		case slices.Contains(p.Deps, importPath):
+			dependents = append(dependents, p.ImportPath+" (transitive dep)")
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("kept because: ")
+	reasons := f.matchReasons[importPath]
+	b.WriteString(strings.Join(reasons, "; "))
+	if len(dependents) > 0 {
+		if len(reasons) > 0 {
+			b.WriteString("; also ")
+		}
+		b.WriteString("required by ")
+		b.WriteString(strings.Join(dependents, ", "))
+	}
+	if len(reasons) == 0 && len(dependents) == 0 {
+		b.WriteString("added to the keep set directly")
+	}
+	return b.String()
+}
@@ -1,18 +1,64 @@
 package pkglist
 
-import "os/exec"
+import (
+	"context"
+	"os"
+	"os/exec"
+)
 
 // Commander executes commands and returns their output
 type Commander interface {
 	Command(name string, args ...string) Command
 }
 
+// ContextCommander is a Commander that can bind commands to a context, killing
+// them when the context is done
+type ContextCommander interface {
+	Commander
+	CommandContext(ctx context.Context, name string, args ...string) Command
+}
+
+// CommandWithContext returns a command from commander bound to ctx when
+// commander is a ContextCommander, and an unbound command otherwise
+func CommandWithContext(ctx context.Context, commander Commander, name string, args ...string) Command {
+	if cc, ok := commander.(ContextCommander); ok {
+		return cc.CommandContext(ctx, name, args...)
+	}
+	return commander.Command(name, args...)
+}
+
 // Command represents a runnable command
 type Command interface {
 	SetDir(dir string)
+	SetEnv(env []string) // Additional KEY=value pairs on top of the current environment
 	Output() ([]byte, error)
+	CombinedOutput() ([]byte, error)
+}
+
+// DependencyObserver is notified as AddDependencies expands the keep set
+type DependencyObserver interface {
+	// OnDependencyAdded is called when pkg is added because addedBy depends on it
+	OnDependencyAdded(pkg, addedBy string)
+}
+
+// DiscoveryObserver is notified as FindAll decodes the output of go list
+type DiscoveryObserver interface {
+	// OnPackagesDiscovered is called with each batch of up to
+	// DiscoveryBatchSize packages found
+	OnPackagesDiscovered(batch []*Package)
+}
+
+// Logger receives the progress messages and warnings that would otherwise go
+// to the standard logger. *log.Logger implements Logger.
+type Logger interface {
+	Printf(format string, v ...any)
 }
 
+// NopLogger is a Logger that discards everything
+type NopLogger struct{}
+
+func (NopLogger) Printf(format string, v ...any) {}
+
 // RealCommander implements Commander using os/exec
 type RealCommander struct{}
 
@@ -22,6 +68,12 @@ func (c *RealCommander) Command(name string, args ...string) Command {
 	}
 }
 
+func (c *RealCommander) CommandContext(ctx context.Context, name string, args ...string) Command {
+	return &RealCommand{
+		cmd: exec.CommandContext(ctx, name, args...),
+	}
+}
+
 // RealCommand wraps exec.Cmd
 type RealCommand struct {
 	cmd *exec.Cmd
@@ -31,6 +83,14 @@ func (c *RealCommand) SetDir(dir string) {
 	c.cmd.Dir = dir
 }
 
+func (c *RealCommand) SetEnv(env []string) {
+	c.cmd.Env = append(os.Environ(), env...)
+}
+
 func (c *RealCommand) Output() ([]byte, error) {
 	return c.cmd.Output()
 }
+
+func (c *RealCommand) CombinedOutput() ([]byte, error) {
+	return c.cmd.CombinedOutput()
+}
@@ -0,0 +1,95 @@
+package pkglist
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// WithModuleGraph enables or disables resolving dependencies at the module
+// level with go mod graph. Instead of following the Deps of each kept
+// package, AddDependencies keeps every package of every module reachable from
+// the modules of the kept packages. This is faster but keeps more packages.
+func WithModuleGraph(enabled bool) Option {
+	return func(f *Finder) {
+		f.moduleGraph = enabled
+	}
+}
+
+// modGraph returns the module dependency graph reported by go mod graph,
+// keyed by module path with versions stripped
+func (f *Finder) modGraph() (map[string][]string, error) {
+	env, err := f.commandEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := f.commander.Command("go", "mod", "graph")
+	cmd.SetDir(f.sourceDir)
+	cmd.SetEnv(env)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run go mod graph: %v", err)
+	}
+
+	graph := make(map[string][]string)
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		from, _, _ := strings.Cut(fields[0], "@")
+		to, _, _ := strings.Cut(fields[1], "@")
+		graph[from] = append(graph[from], to)
+	}
+	return graph, scanner.Err()
+}
+
+// addModuleDependencies adds to keepPackages every package belonging to a
+// module reachable from the modules of the packages already kept
+func (f *Finder) addModuleDependencies(keepPackages map[string]struct{}) error {
+	graph, err := f.modGraph()
+	if err != nil {
+		return err
+	}
+
+	reachable := make(map[string]struct{})
+	var toProcess []string
+	for pkg := range keepPackages {
+		p, ok := f.packages[pkg]
+		if !ok || p.Module == nil {
+			continue
+		}
+		if _, seen := reachable[p.Module.Path]; !seen {
+			reachable[p.Module.Path] = struct{}{}
+			toProcess = append(toProcess, p.Module.Path)
+		}
+	}
+	for i := 0; i < len(toProcess); i++ {
+		for _, dep := range graph[toProcess[i]] {
+			if _, seen := reachable[dep]; !seen {
+				reachable[dep] = struct{}{}
+				toProcess = append(toProcess, dep)
+			}
+		}
+	}
+
+	for _, p := range f.Packages() {
+		if p.Module == nil {
+			continue
+		}
+		if _, ok := reachable[p.Module.Path]; !ok {
+			continue
+		}
+		if _, ok := keepPackages[p.ImportPath]; ok {
+			continue
+		}
+		keepPackages[p.ImportPath] = struct{}{}
+		f.logf("  Adding package %s from module %s", p.ImportPath, p.Module.Path)
+		if f.depObserver != nil {
+			f.depObserver.OnDependencyAdded(p.ImportPath, p.Module.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package pkglist
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// WithScanNonGo makes FindAll look in each package directory for files with
+// one of the given extensions (e.g. ".proto", ".graphql"), which go list does
+// not always report. They are available through NonGoFiles and
+// GetNonGoFileList.
+func WithScanNonGo(extensions []string) Option {
+	return func(f *Finder) {
+		f.nonGoExts = make(map[string]struct{}, len(extensions))
+		for _, ext := range extensions {
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			f.nonGoExts[ext] = struct{}{}
+		}
+	}
+}
+
+// NonGoFiles returns the files found by WithScanNonGo, keyed by the import
+// path of the package whose directory contains them
+func (f *Finder) NonGoFiles() map[string][]string {
+	return f.nonGoFiles
+}
+
+// GetNonGoFileList returns the files found by WithScanNonGo in the
+// directories of the kept packages, sorted
+func (f *Finder) GetNonGoFileList(keepPackages PkgSet) []string {
+	var files []string
+	for importPath := range keepPackages {
+		files = append(files, f.nonGoFiles[importPath]...)
+	}
+	sort.Strings(files)
+	return dedupFiles(files)
+}
+
+// scanNonGoFiles records the files matching the WithScanNonGo extensions in
+// the directory of every package found
+func (f *Finder) scanNonGoFiles() {
+	f.nonGoFiles = make(map[string][]string)
+	for importPath, pkg := range f.packages {
+		entries, err := afero.ReadDir(f.fs, pkg.Dir)
+		if err != nil {
+			f.logf("Warning: failed to scan %s for non-Go files: %v", pkg.Dir, err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			if _, ok := f.nonGoExts[filepath.Ext(entry.Name())]; ok {
+				f.nonGoFiles[importPath] = append(f.nonGoFiles[importPath], filepath.Join(pkg.Dir, entry.Name()))
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package pkglist
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pattern is a parsed package pattern
+type Pattern struct {
+	Raw        string // The pattern as given
+	IsWildcard bool   // Ends in "/...", matching all packages below Prefix
+	IsNegation bool   // Starts with "!", removing matches from the keep set
+	IsRegex    bool   // Starts with "^", anchoring Prefix at the module root
+	IsName     bool   // Starts with "name:", matching the package clause name
+	Prefix     string // The pattern without any of the markers above
+}
+
+// PatternParser parses and validates package patterns
+type PatternParser struct{}
+
+// Parse parses patterns, returning an error describing every invalid pattern
+func (PatternParser) Parse(patterns []string) ([]*Pattern, error) {
+	parsed := make([]*Pattern, 0, len(patterns))
+	var errs []error
+	for _, raw := range patterns {
+		p, err := parsePattern(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %q: %w", raw, err))
+			continue
+		}
+		parsed = append(parsed, p)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return parsed, nil
+}
+
+func parsePattern(raw string) (*Pattern, error) {
+	p := &Pattern{Raw: raw}
+	rest := strings.TrimSpace(raw)
+	if rest == "" {
+		return nil, errors.New("empty pattern")
+	}
+
+	rest, p.IsNegation = strings.CutPrefix(rest, "!")
+	rest, p.IsName = strings.CutPrefix(rest, "name:")
+	rest, p.IsRegex = strings.CutPrefix(rest, "^")
+	if rest == "..." {
+		rest, p.IsWildcard = ".", true
+	} else {
+		rest, p.IsWildcard = strings.CutSuffix(rest, "/...")
+	}
+	p.Prefix = rest
+
+	switch {
+	case rest == "":
+		return nil, errors.New("missing package path")
+	case p.IsName && (p.IsRegex || p.IsWildcard || strings.Contains(rest, "/")):
+		return nil, errors.New("name patterns take a bare package name")
+	case strings.Contains(rest, "..."):
+		return nil, errors.New(`"..." is only supported as a final "/..." element`)
+	case strings.HasPrefix(rest, "!"):
+		return nil, errors.New(`"!" must be the first character`)
+	}
+	return p, nil
+}
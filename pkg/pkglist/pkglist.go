@@ -1,107 +1,686 @@
 package pkglist
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/spf13/afero"
 )
 
 // Package represents a Go package with its files and dependencies
 type Package struct {
-	Dir          string
-	ImportPath   string
-	Deps         []string
-	EmbedFiles   []string // Files embedded using //go:embed
-	GoFiles      []string // Regular .go files
-	TestGoFiles  []string // Test .go files
-	OtherFiles   []string // Non-Go files in the package directory
-	XTestGoFiles []string // Add this field
+	Dir            string
+	ImportPath     string
+	Name           string        // Package name from the package clause
+	Imports        []string      // Direct imports
+	Deps           []string      // Transitive dependencies
+	EmbedPatterns  []string      // Patterns of //go:embed directives
+	EmbedFiles     []string      // Files embedded using //go:embed
+	GoFiles        []string      // Regular .go files
+	CgoFiles       []string      // .go files that import "C"
+	IgnoredGoFiles []string      // .go files excluded by build constraints
+	TestGoFiles    []string      // Test .go files
+	OtherFiles     []string      // Non-Go files in the package directory
+	XTestGoFiles   []string      // External test .go files (package foo_test)
+	Module         *Module       // Module containing the package, nil outside module mode
+	GoMod          string        `json:"-"` // go.mod file of the containing module
+	IsMain         bool          `json:"-"` // Whether this is a main package
+	DepOnly        bool          // Only listed as a dependency of another package
+	Error          *PackageError // Error loading the package, set with go list -e
+}
+
+// PackageError describes an error loading a package, as reported by go list
+type PackageError struct {
+	ImportStack []string // Shortest path from a listed package to this one
+	Pos         string   // Position of the error, if known
+	Err         string   // The error itself
+}
+
+func (e *PackageError) Error() string {
+	if e.Pos != "" {
+		return e.Pos + ": " + e.Err
+	}
+	return e.Err
+}
+
+// PkgSet is a set of package import paths
+type PkgSet = map[string]struct{}
+
+// Module describes the Go module a package belongs to
+type Module struct {
+	Path      string
+	Dir       string
+	GoMod     string
+	GoVersion string
+	Main      bool
 }
 
 // Finder handles discovering and filtering Go packages
 type Finder struct {
-	sourceDir string
-	packages  map[string]*Package
-	fs        afero.Fs
-	commander Commander
+	sourceDir      string
+	modulePath     string // path of the module of the first package found
+	packages       map[string]*Package
+	fs             afero.Fs
+	commander      Commander
+	expandPatterns bool
+	removed        map[string]string
+	matched        map[string]struct{} // packages matched explicitly by a pattern
+	matchReasons   map[string][]string // why each matched package was matched
+	keep           map[string]struct{} // keep set last expanded by AddDependencies
+	timing         map[string]time.Duration
+	depObserver    DependencyObserver
+	discObserver   DiscoveryObserver
+	logger         Logger
+	autoDownload   bool
+	nameFilter     func(name string) bool
+	goos           string
+	goarch         string
+	maxFiles       int
+	moduleGraph    bool
+	deepEmbedScan  bool
+	depsMode       bool
+	buildTags      []string
+	errorTolerant  bool
+	ignoreReplace  bool
+	minGoVersion   string
+	goModVersions  map[string]string // go directive of each go.mod read
+	nonGoExts      map[string]struct{}
+	nonGoFiles     map[string][]string
+
+	prebuiltBinaries           bool
+	excludeCrossModuleInternal bool
+}
+
+type Option func(*Finder)
+
+// WithExpandPatterns enables or disables resolving short patterns to the full
+// import path of the packages they name
+func WithExpandPatterns(expand bool) Option {
+	return func(f *Finder) {
+		f.expandPatterns = expand
+	}
+}
+
+// WithExcludeCrossModuleInternal enables or disables skipping internal
+// packages that a kept package imports from outside their allowed tree
+func WithExcludeCrossModuleInternal(exclude bool) Option {
+	return func(f *Finder) {
+		f.excludeCrossModuleInternal = exclude
+	}
+}
+
+// WithDependencyObserver registers an observer notified of every package added
+// by AddDependencies
+func WithDependencyObserver(obs DependencyObserver) Option {
+	return func(f *Finder) {
+		f.depObserver = obs
+	}
+}
+
+// DiscoveryBatchSize is the number of packages passed to a DiscoveryObserver
+// at a time
+const DiscoveryBatchSize = 100
+
+// WithDiscoveryObserver registers an observer notified of the packages found
+// by FindAll, in batches of DiscoveryBatchSize
+func WithDiscoveryObserver(obs DiscoveryObserver) Option {
+	return func(f *Finder) {
+		f.discObserver = obs
+	}
+}
+
+// WithAutoDownload enables or disables running go mod download before listing
+// packages
+func WithAutoDownload(enabled bool) Option {
+	return func(f *Finder) {
+		f.autoDownload = enabled
+	}
+}
+
+// WithPackageNameFilter restricts FilterByPatterns to packages whose package
+// clause name satisfies fn
+func WithPackageNameFilter(fn func(name string) bool) Option {
+	return func(f *Finder) {
+		f.nameFilter = fn
+	}
+}
+
+// WithDepsMode enables or disables listing packages with go list -e -deps,
+// which reports the full transitive closure of the repository packages in a
+// single invocation. Dependencies inside the source directory are kept even
+// if ./... does not match them, and AddDependencies no longer needs to walk
+// the dependency graph. Packages outside the source directory, including the
+// standard library, are dropped.
+func WithDepsMode(enabled bool) Option {
+	return func(f *Finder) {
+		f.depsMode = enabled
+	}
+}
+
+// WithBuildTags lists packages with the given build tags set, so that files
+// guarded by matching //go:build constraints are included
+func WithBuildTags(tags []string) Option {
+	return func(f *Finder) {
+		f.buildTags = tags
+	}
+}
+
+// WithErrorTolerant enables or disables listing packages with go list -e, so
+// that packages that fail to load are still recorded, with their Error set,
+// instead of making FindAll fail
+func WithErrorTolerant(enabled bool) Option {
+	return func(f *Finder) {
+		f.errorTolerant = enabled
+	}
+}
+
+// WithLogger sets the logger progress messages and warnings are written to.
+// By default they go to the standard logger; use NopLogger to discard them.
+func WithLogger(l Logger) Option {
+	return func(f *Finder) {
+		f.logger = l
+	}
+}
+
+// logf writes a message to the configured logger, or to the standard logger
+// when none is set
+func (f *Finder) logf(format string, v ...any) {
+	if f.logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	f.logger.Printf(format, v...)
 }
 
 // NewFinder creates a new package finder for the given source directory
-func NewFinder(sourceDir string) *Finder {
-	return &Finder{
+func NewFinder(sourceDir string, opts ...Option) *Finder {
+	f := &Finder{
 		sourceDir: sourceDir,
 		packages:  make(map[string]*Package),
+		removed:   make(map[string]string),
 		fs:        afero.NewOsFs(),
 		commander: &RealCommander{},
 	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
 }
 
 // FindAll discovers all packages in the repository
 func (f *Finder) FindAll() error {
-	cmd := f.commander.Command("go", "list", "-json", "./...")
+	return f.FindAllContext(context.Background())
+}
+
+// FindAllContext is like FindAll but kills go list and returns ctx.Err() when
+// ctx is done
+func (f *Finder) FindAllContext(ctx context.Context) error {
+	defer f.recordTiming("FindAll", time.Now())
+
+	env, err := f.commandEnv()
+	if err != nil {
+		return err
+	}
+
+	if f.ignoreReplace {
+		modFile, err := f.writeModFileWithoutReplace()
+		if err != nil {
+			return fmt.Errorf("failed to drop replace directives: %v", err)
+		}
+		defer f.fs.RemoveAll(filepath.Dir(modFile))
+		env = append(env, "GOFLAGS=-mod=mod -modfile="+modFile)
+	}
+
+	if f.autoDownload {
+		if err := f.downloadModules(ctx, env); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"list"}
+	if len(f.buildTags) > 0 {
+		args = append(args, "-tags="+strings.Join(f.buildTags, ","))
+	}
+	if f.errorTolerant || f.depsMode {
+		args = append(args, "-e")
+	}
+	args = append(args, "-json")
+	if f.depsMode {
+		args = append(args, "-deps")
+	}
+	args = append(args, "./...")
+	cmd := CommandWithContext(ctx, f.commander, "go", args...)
 	cmd.SetDir(f.sourceDir)
+	cmd.SetEnv(env)
 
 	out, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to list packages: %v", err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if !f.errorTolerant || len(out) == 0 {
+			return fmt.Errorf("failed to list packages: %v", err)
+		}
+		f.logf("Warning: go list failed, using the packages it listed: %v", err)
+	}
+
+	var batch []*Package
+	flush := func() {
+		if f.discObserver != nil && len(batch) > 0 {
+			f.discObserver.OnPackagesDiscovered(batch)
+		}
+		batch = nil
 	}
 
 	decoder := json.NewDecoder(strings.NewReader(string(out)))
 	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("failed to decode package info: %v", err)
+		}
 		var pkg Package
-		if err := decoder.Decode(&pkg); err != nil {
+		if err := json.Unmarshal(raw, &pkg); err != nil {
 			return fmt.Errorf("failed to decode package info: %v", err)
 		}
+		if pkg.ImportPath == "" {
+			f.logf("Warning: skipping package with empty import path: %s", raw)
+			continue
+		}
+		if pkg.DepOnly && !f.underSourceDir(pkg.Dir) {
+			continue
+		}
+		if pkg.Error != nil {
+			f.logf("Warning: package %s has errors: %v", pkg.ImportPath, pkg.Error)
+		}
+		if pkg.Module != nil {
+			pkg.GoMod = pkg.Module.GoMod
+		}
+		pkg.IsMain = pkg.Name == "main"
+		if existing, ok := f.packages[pkg.ImportPath]; ok {
+			f.logf("Warning: duplicate package %s at %s and %s", pkg.ImportPath, existing.Dir, pkg.Dir)
+			if f.underSourceDir(existing.Dir) || !f.underSourceDir(pkg.Dir) {
+				continue
+			}
+		}
+		if f.modulePath == "" && pkg.Module != nil {
+			f.modulePath = pkg.Module.Path
+		}
 		f.packages[pkg.ImportPath] = &pkg
-		log.Printf("Found package: %s at %s", pkg.ImportPath, pkg.Dir)
+		f.logf("Found package: %s at %s", pkg.ImportPath, pkg.Dir)
+
+		batch = append(batch, &pkg)
+		if len(batch) == DiscoveryBatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	if len(f.nonGoExts) > 0 {
+		f.scanNonGoFiles()
 	}
 
 	return nil
 }
 
-// FilterByPatterns returns packages matching the given patterns
+// ModulePath returns the path of the repository's module, as reported for the
+// first package found by FindAll, or "" if none was found in module mode
+func (f *Finder) ModulePath() string {
+	return f.modulePath
+}
+
+// WithMaxFilesPerPackage makes AddDependencies remove from the keep set any
+// package with more than n source files (GoFiles and OtherFiles), however it
+// was added. Zero means no limit.
+func WithMaxFilesPerPackage(n int) Option {
+	return func(f *Finder) {
+		f.maxFiles = n
+	}
+}
+
+// underSourceDir reports whether dir is the source directory or inside it
+func (f *Finder) underSourceDir(dir string) bool {
+	rel, err := filepath.Rel(f.sourceDir, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// FilterByPatterns returns packages matching the given patterns. Negation
+// patterns ("!" prefix) are applied once every other pattern has been, so they
+// exclude packages whatever their position in the list.
 func (f *Finder) FilterByPatterns(patterns []string) map[string]struct{} {
+	defer f.recordTiming("FilterByPatterns", time.Now())
+
 	keepPackages := make(map[string]struct{})
 	for _, pattern := range patterns {
-		log.Printf("Processing pattern: %s", pattern)
-		for _, pkg := range f.packages {
-			if f.matchPackage(pattern, pkg.ImportPath, pkg.Dir) {
-				log.Printf("  Matched package: %s at %s", pkg.ImportPath, pkg.Dir)
-				keepPackages[pkg.ImportPath] = struct{}{}
+		// Negation patterns are applied last
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+
+		f.logf("Processing pattern: %s", pattern)
+		resolved := []string{pattern}
+		if f.expandPatterns {
+			resolved = f.expandPattern(pattern)
+		}
+		for _, p := range resolved {
+			for _, pkg := range f.packages {
+				if f.nameFilter != nil && !f.nameFilter(pkg.Name) {
+					continue
+				}
+				if f.matchPackage(p, pkg) {
+					if goVersion, newer := f.requiresNewerGo(pkg); newer {
+						f.logf("Warning: excluding package %s requiring Go %s (pinned to %s)", pkg.ImportPath, goVersion, f.minGoVersion)
+						continue
+					}
+					f.logf("  Matched package: %s at %s", pkg.ImportPath, pkg.Dir)
+					keepPackages[pkg.ImportPath] = struct{}{}
+					f.markMatched(pkg.ImportPath, fmt.Sprintf("matched pattern '%s'", pattern))
+				}
 			}
 		}
 	}
+
+	f.FilterByNegation(keepPackages, patterns)
+	return keepPackages
+}
+
+// FilterByNameFunc returns packages for which fn returns true
+func (f *Finder) FilterByNameFunc(fn func(*Package) bool) map[string]struct{} {
+	keepPackages := make(map[string]struct{})
+	for _, pkg := range f.packages {
+		if fn(pkg) {
+			f.logf("  Selected package: %s at %s", pkg.ImportPath, pkg.Dir)
+			keepPackages[pkg.ImportPath] = struct{}{}
+			f.markMatched(pkg.ImportPath, "selected by filter")
+		}
+	}
 	return keepPackages
 }
 
-// AddDependencies adds all dependencies of the kept packages to the keep set
+// FilterByNegation removes from keepPackages every package matching a negation
+// pattern ("!" prefix). Patterns without the prefix are ignored. Removed
+// packages are recorded and available through RemovedPackages. FilterByPatterns
+// already applies negation patterns to the packages it matches; this is for
+// keep sets extended by other means, such as FilterByGlobs.
+func (f *Finder) FilterByNegation(keepPackages map[string]struct{}, patterns []string) {
+	for _, pattern := range patterns {
+		negated, ok := strings.CutPrefix(pattern, "!")
+		if !ok {
+			continue
+		}
+
+		f.logf("Processing negation pattern: %s", pattern)
+		for importPath := range keepPackages {
+			pkg, ok := f.packages[importPath]
+			if !ok || !f.matchPackage(negated, pkg) {
+				continue
+			}
+			f.logf("  Removed package: %s at %s", pkg.ImportPath, pkg.Dir)
+			delete(keepPackages, importPath)
+			delete(f.matched, importPath)
+			delete(f.matchReasons, importPath)
+			if f.removed == nil {
+				f.removed = make(map[string]string)
+			}
+			f.removed[importPath] = pattern
+		}
+	}
+}
+
+// markMatched records that importPath was matched explicitly, with a
+// human-readable reason such as "matched pattern 'foo/...'"
+func (f *Finder) markMatched(importPath, reason string) {
+	if f.matched == nil {
+		f.matched = make(map[string]struct{})
+	}
+	f.matched[importPath] = struct{}{}
+
+	if f.matchReasons == nil {
+		f.matchReasons = make(map[string][]string)
+	}
+	for _, r := range f.matchReasons[importPath] {
+		if r == reason {
+			return
+		}
+	}
+	f.matchReasons[importPath] = append(f.matchReasons[importPath], reason)
+}
+
+// RemovedPackages returns the packages removed from the keep set by negation
+// patterns, mapped to the pattern that removed them
+func (f *Finder) RemovedPackages() map[string]string {
+	return f.removed
+}
+
+// expandPattern resolves a short, non-wildcard pattern to the full import paths
+// of the packages it names. Patterns that are wildcards, that already match an
+// import path verbatim, or that resolve to nothing are returned unchanged.
+func (f *Finder) expandPattern(pattern string) []string {
+	if strings.HasSuffix(pattern, "/...") || strings.HasPrefix(pattern, "name:") || strings.HasPrefix(pattern, "^") {
+		return []string{pattern}
+	}
+	if _, ok := f.packages[pattern]; ok {
+		return []string{pattern}
+	}
+
+	var expanded []string
+	for importPath := range f.packages {
+		if strings.HasSuffix(importPath, "/"+pattern) {
+			expanded = append(expanded, importPath)
+		}
+	}
+	if len(expanded) == 0 {
+		return []string{pattern}
+	}
+
+	sort.Strings(expanded)
+	for _, importPath := range expanded {
+		f.logf("  Expanded pattern '%s' to '%s'", pattern, importPath)
+	}
+	return expanded
+}
+
+// AddDependencies adds all dependencies of the kept packages to the keep set,
+// then removes the packages over the WithMaxFilesPerPackage limit
 func (f *Finder) AddDependencies(keepPackages map[string]struct{}) {
-	toProcess := make([]string, 0, len(keepPackages))
+	defer f.recordTiming("AddDependencies", time.Now())
+
+	f.keep = keepPackages
+	f.addDependencies(keepPackages)
+	f.excludeLargePackages(keepPackages)
+}
+
+// addDependencies adds all dependencies of the kept packages to keepPackages
+func (f *Finder) addDependencies(keepPackages map[string]struct{}) {
+	if f.moduleGraph {
+		err := f.addModuleDependencies(keepPackages)
+		if err == nil {
+			return
+		}
+		f.logf("Warning: falling back to package dependencies: %v", err)
+	}
+
+	if f.depsMode {
+		f.addListedDependencies(keepPackages)
+		return
+	}
+
+	roots := make([]string, 0, len(keepPackages))
 	for pkg := range keepPackages {
-		toProcess = append(toProcess, pkg)
+		roots = append(roots, pkg)
 	}
+	f.walkDeps(roots, keepPackages, func(dep, addedBy string) {
+		if f.depObserver != nil {
+			f.depObserver.OnDependencyAdded(dep, addedBy)
+		}
+	})
+}
 
-	for i := 0; i < len(toProcess); i++ {
-		pkg := toProcess[i]
-		if p, ok := f.packages[pkg]; ok {
-			for _, dep := range p.Deps {
-				if _, ok := keepPackages[dep]; !ok {
-					if _, inRepo := f.packages[dep]; inRepo {
-						keepPackages[dep] = struct{}{}
-						toProcess = append(toProcess, dep)
-					}
+// excludeLargePackages removes from keepPackages every package with more
+// source files than the WithMaxFilesPerPackage limit
+func (f *Finder) excludeLargePackages(keepPackages map[string]struct{}) {
+	if f.maxFiles <= 0 {
+		return
+	}
+	for importPath := range keepPackages {
+		pkg, ok := f.packages[importPath]
+		if !ok {
+			continue
+		}
+		if n := len(pkg.GoFiles) + len(pkg.OtherFiles); n > f.maxFiles {
+			f.logf("Warning: excluding package %s with %d files (limit %d)", importPath, n, f.maxFiles)
+			delete(keepPackages, importPath)
+		}
+	}
+}
+
+// addListedDependencies adds the Deps of every kept package to keepPackages.
+// In deps mode every dependency was listed by FindAll and Deps is already
+// transitive, so a single pass is enough.
+func (f *Finder) addListedDependencies(keepPackages map[string]struct{}) {
+	roots := make([]string, 0, len(keepPackages))
+	for pkg := range keepPackages {
+		roots = append(roots, pkg)
+	}
+	sort.Strings(roots)
+
+	for _, pkg := range roots {
+		p, ok := f.packages[pkg]
+		if !ok {
+			continue
+		}
+		for _, dep := range p.Deps {
+			if f.excludeCrossModuleInternal && !f.internalAllowed(p, dep) {
+				f.logf("  Skipping internal package %s imported by %s", dep, pkg)
+				continue
+			}
+			if _, ok := keepPackages[dep]; ok {
+				continue
+			}
+			if _, inRepo := f.packages[dep]; inRepo {
+				keepPackages[dep] = struct{}{}
+				if f.depObserver != nil {
+					f.depObserver.OnDependencyAdded(dep, pkg)
 				}
 			}
 		}
 	}
 }
 
+// ExcludePackages removes from keepPackages the packages matching any of
+// patterns, typically once AddDependencies has run. Excluded packages that
+// remaining kept packages still depend on are kept with a warning. It returns
+// the import paths of the packages removed, sorted.
+func (f *Finder) ExcludePackages(keepPackages map[string]struct{}, patterns []string) []string {
+	excluded := make(map[string]string)
+	for importPath := range keepPackages {
+		pkg, ok := f.packages[importPath]
+		if !ok {
+			continue
+		}
+		for _, pattern := range patterns {
+			if f.matchPackage(pattern, pkg) {
+				excluded[importPath] = pattern
+				break
+			}
+		}
+	}
+
+	// Packages depended on by a surviving package survive too, which may in
+	// turn make other excluded packages needed
+	needed := make(map[string]string)
+	toProcess := make([]string, 0, len(keepPackages))
+	for importPath := range keepPackages {
+		if _, ok := excluded[importPath]; !ok {
+			toProcess = append(toProcess, importPath)
+		}
+	}
+	sort.Strings(toProcess)
+	for i := 0; i < len(toProcess); i++ {
+		p, ok := f.packages[toProcess[i]]
+		if !ok {
+			continue
+		}
+		for _, dep := range p.Deps {
+			if _, ok := excluded[dep]; !ok {
+				continue
+			}
+			if _, ok := needed[dep]; !ok {
+				needed[dep] = toProcess[i]
+				toProcess = append(toProcess, dep)
+			}
+		}
+	}
+
+	var removed []string
+	for importPath, pattern := range excluded {
+		if neededBy, ok := needed[importPath]; ok {
+			f.logf("Warning: keeping excluded package %s (%s) required by %s", importPath, pattern, neededBy)
+			continue
+		}
+		f.logf("  Excluded package: %s (%s)", importPath, pattern)
+		delete(keepPackages, importPath)
+		removed = append(removed, importPath)
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// internalAllowed reports whether pkg may import dep directly under Go's rule
+// that an internal package is only importable from the tree rooted at the
+// parent of its internal directory. Transitive dependencies are always allowed
+// since they are reached through an intermediate package.
+func (f *Finder) internalAllowed(pkg *Package, dep string) bool {
+	direct := false
+	for _, imp := range pkg.Imports {
+		if imp == dep {
+			direct = true
+			break
+		}
+	}
+	if !direct {
+		return true
+	}
+
+	var parent string
+	switch {
+	case strings.HasSuffix(dep, "/internal"):
+		parent = strings.TrimSuffix(dep, "/internal")
+	case strings.Contains(dep, "/internal/"):
+		parent = dep[:strings.LastIndex(dep, "/internal/")]
+	default:
+		return true
+	}
+
+	return pkg.ImportPath == parent || strings.HasPrefix(pkg.ImportPath, parent+"/")
+}
+
+// GetFileListOptions controls which files GetFileList returns
+type GetFileListOptions struct {
+	WithTests      bool // Include test files, external test files and testdata
+	IncludeIgnored bool // Include .go files excluded by build constraints
+	IncludeCgo     bool // Include .go files that import "C"
+}
+
+// GetFileListWithTests returns all files from the kept packages, including
+// test files if withTests is set.
+//
+// Deprecated: use GetFileList with GetFileListOptions.
+func (f *Finder) GetFileListWithTests(keepPackages PkgSet, withTests bool) []string {
+	return f.GetFileList(keepPackages, GetFileListOptions{WithTests: withTests})
+}
+
 // GetFileList returns all files from the kept packages
-func (f *Finder) GetFileList(keepPackages map[string]struct{}, withTests bool) []string {
+func (f *Finder) GetFileList(keepPackages PkgSet, opts GetFileListOptions) []string {
+	defer f.recordTiming("GetFileList", time.Now())
+
 	var allFiles []string
 	for pkgPath, pkg := range f.packages {
 		if _, keep := keepPackages[pkgPath]; !keep {
@@ -110,26 +689,47 @@ func (f *Finder) GetFileList(keepPackages map[string]struct{}, withTests bool) [
 
 		// Add all Go files from the package
 		for _, file := range pkg.GoFiles {
-			allFiles = append(allFiles, filepath.Join(pkg.Dir, file))
+			absPath := filepath.Join(pkg.Dir, file)
+			if !f.sourceFileExists(absPath) {
+				continue
+			}
+			allFiles = append(allFiles, absPath)
+		}
+
+		// Add cgo and constraint-excluded files if requested
+		var extraGoFiles []string
+		if opts.IncludeCgo {
+			extraGoFiles = append(extraGoFiles, pkg.CgoFiles...)
+		}
+		if opts.IncludeIgnored {
+			extraGoFiles = append(extraGoFiles, pkg.IgnoredGoFiles...)
+		}
+		for _, file := range extraGoFiles {
+			absPath := filepath.Join(pkg.Dir, file)
+			if !f.sourceFileExists(absPath) {
+				continue
+			}
+			allFiles = append(allFiles, absPath)
+			f.logf("  Keeping file: %s", absPath)
 		}
 
 		// Add test files if requested
-		if withTests {
+		if opts.WithTests {
 			for _, file := range pkg.TestGoFiles {
 				allFiles = append(allFiles, filepath.Join(pkg.Dir, file))
-				log.Printf("  Keeping test file: %s", filepath.Join(pkg.Dir, file))
+				f.logf("  Keeping test file: %s", filepath.Join(pkg.Dir, file))
 			}
 
 			// Add external test files
 			for _, file := range pkg.XTestGoFiles {
 				allFiles = append(allFiles, filepath.Join(pkg.Dir, file))
-				log.Printf("  Keeping external test file: %s", filepath.Join(pkg.Dir, file))
+				f.logf("  Keeping external test file: %s", filepath.Join(pkg.Dir, file))
 			}
 
 			// Add all other files when tests are included
 			for _, file := range pkg.OtherFiles {
 				allFiles = append(allFiles, filepath.Join(pkg.Dir, file))
-				log.Printf("  Keeping other file: %s", filepath.Join(pkg.Dir, file))
+				f.logf("  Keeping other file: %s", filepath.Join(pkg.Dir, file))
 			}
 		} else {
 			// If not keeping tests, only add non-testdata files
@@ -137,7 +737,7 @@ func (f *Finder) GetFileList(keepPackages map[string]struct{}, withTests bool) [
 				absPath := filepath.Join(pkg.Dir, file)
 				if !strings.Contains(absPath, "/testdata/") {
 					allFiles = append(allFiles, absPath)
-					log.Printf("  Keeping other file: %s", absPath)
+					f.logf("  Keeping other file: %s", absPath)
 				}
 			}
 		}
@@ -145,72 +745,249 @@ func (f *Finder) GetFileList(keepPackages map[string]struct{}, withTests bool) [
 		// Add all embedded files
 		for _, file := range pkg.EmbedFiles {
 			allFiles = append(allFiles, filepath.Join(pkg.Dir, file))
-			log.Printf("  Keeping embedded file: %s", filepath.Join(pkg.Dir, file))
+			f.logf("  Keeping embedded file: %s", filepath.Join(pkg.Dir, file))
+		}
+		if f.deepEmbedScan {
+			for _, file := range f.embeddedDirFiles(pkg) {
+				allFiles = append(allFiles, file)
+				f.logf("  Keeping file from embedded directory: %s", file)
+			}
+		}
+	}
+
+	if f.prebuiltBinaries {
+		for _, file := range f.findPrebuiltBinaries() {
+			allFiles = append(allFiles, file)
+			f.logf("  Keeping prebuilt binary: %s", file)
+		}
+	}
+
+	// Packages sharing a directory (e.g. build-tag or test variants) report
+	// the same files
+	return dedupFiles(allFiles)
+}
+
+// Packages returns all discovered packages, sorted by import path
+func (f *Finder) Packages() []*Package {
+	pkgs := make([]*Package, 0, len(f.packages))
+	for _, pkg := range f.packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].ImportPath < pkgs[j].ImportPath
+	})
+	return pkgs
+}
+
+// MainPackages returns the discovered main packages, sorted by import path
+func (f *Finder) MainPackages() []*Package {
+	var mains []*Package
+	for _, pkg := range f.Packages() {
+		if pkg.IsMain {
+			mains = append(mains, pkg)
+		}
+	}
+	return mains
+}
+
+// ListUnused returns the discovered packages not in keepPackages, sorted by
+// import path
+func (f *Finder) ListUnused(keepPackages PkgSet) []*Package {
+	var unused []*Package
+	for _, pkg := range f.Packages() {
+		if _, ok := keepPackages[pkg.ImportPath]; !ok {
+			unused = append(unused, pkg)
+		}
+	}
+	return unused
+}
+
+// PackagesByDir groups the discovered packages by directory, sorted by import path
+func (f *Finder) PackagesByDir() map[string][]*Package {
+	byDir := make(map[string][]*Package)
+	for _, pkg := range f.packages {
+		byDir[pkg.Dir] = append(byDir[pkg.Dir], pkg)
+	}
+	for _, pkgs := range byDir {
+		sort.Slice(pkgs, func(i, j int) bool {
+			return pkgs[i].ImportPath < pkgs[j].ImportPath
+		})
+	}
+	return byDir
+}
+
+// PackagesByMod groups the discovered packages by the go.mod file of their
+// module, sorted by import path. Packages outside module mode are skipped.
+func (f *Finder) PackagesByMod() map[string][]*Package {
+	byMod := make(map[string][]*Package)
+	for _, pkg := range f.Packages() {
+		if pkg.GoMod == "" {
+			continue
+		}
+		byMod[pkg.GoMod] = append(byMod[pkg.GoMod], pkg)
+	}
+	return byMod
+}
+
+// dedupFiles removes duplicate paths, preserving the order of first occurrence
+func dedupFiles(files []string) []string {
+	seen := make(map[string]struct{}, len(files))
+	deduped := files[:0]
+	for _, file := range files {
+		if _, ok := seen[file]; ok {
+			continue
 		}
+		seen[file] = struct{}{}
+		deduped = append(deduped, file)
+	}
+	return deduped
+}
+
+// FinderMetrics summarises the packages discovered by a Finder
+type FinderMetrics struct {
+	PackageCount        int // Packages discovered
+	FileCount           int // Go and test Go files of those packages
+	DependencyEdgeCount int // Sum of the transitive dependencies of each package
+}
+
+// Metrics returns counts describing the packages discovered by FindAll
+func (f *Finder) Metrics() FinderMetrics {
+	m := FinderMetrics{PackageCount: len(f.packages)}
+	for _, pkg := range f.packages {
+		m.FileCount += len(pkg.GoFiles) + len(pkg.CgoFiles) + len(pkg.TestGoFiles) + len(pkg.XTestGoFiles)
+		m.DependencyEdgeCount += len(pkg.Deps)
 	}
-	return allFiles
+	return m
+}
+
+// Timing returns the wall-clock time spent in each phase of the last run,
+// keyed by method name
+func (f *Finder) Timing() map[string]time.Duration {
+	timing := make(map[string]time.Duration, len(f.timing))
+	for phase, d := range f.timing {
+		timing[phase] = d
+	}
+	return timing
+}
+
+func (f *Finder) recordTiming(phase string, start time.Time) {
+	if f.timing == nil {
+		f.timing = make(map[string]time.Duration)
+	}
+	f.timing[phase] = time.Since(start)
+	f.logf("Finder phase %s took %v", phase, f.timing[phase])
+}
+
+// sourceFileExists reports whether a Go file reported by go list is part of the
+// source tree. CGo-generated files (_cgo_*) only live in the build cache.
+func (f *Finder) sourceFileExists(path string) bool {
+	if strings.HasPrefix(filepath.Base(path), "_cgo_") {
+		f.logf("  Skipping CGo-generated file: %s", path)
+		return false
+	}
+
+	exists, err := afero.Exists(f.fs, path)
+	if err != nil {
+		f.logf("  Failed to check %s: %v", path, err)
+		return false
+	}
+	if !exists {
+		f.logf("  Skipping missing file: %s", path)
+	}
+	return exists
 }
 
 // matchPackage checks if a package matches the given pattern
-func (f *Finder) matchPackage(pattern, importPath, dir string) bool {
+func (f *Finder) matchPackage(pattern string, pkg *Package) bool {
+	// Match against the package clause name for "name:" patterns
+	if name, ok := strings.CutPrefix(pattern, "name:"); ok {
+		f.logf("    Matching name '%s' against package '%s' (%s)", name, pkg.Name, pkg.ImportPath)
+		return pkg.Name == name
+	}
+
 	// Convert paths to slash form for comparison
 	pattern = filepath.ToSlash(pattern)
-	importPath = filepath.ToSlash(importPath)
-	dir = filepath.ToSlash(dir)
+	importPath := filepath.ToSlash(pkg.ImportPath)
+	dir := filepath.ToSlash(pkg.Dir)
+
+	// "^" anchors the pattern at the root of the package's module
+	if anchored, ok := strings.CutPrefix(pattern, "^"); ok {
+		if pkg.Module == nil {
+			f.logf("    -> No module to anchor '%s' to", pattern)
+			return false
+		}
+		root := pkg.Module.Path + "/" + strings.TrimSuffix(anchored, "/...")
+		f.logf("    Matching anchored root '%s' against import '%s'", root, importPath)
+		return importPath == root || strings.HasPrefix(importPath, root+"/")
+	}
+
+	// "./" makes the pattern relative to the repository's module
+	if rel, ok := strings.CutPrefix(pattern, "./"); ok && rel != "..." && f.modulePath != "" {
+		relImportPath, inModule := strings.CutPrefix(importPath, f.modulePath+"/")
+		if !inModule {
+			return false
+		}
+		if prefix, ok := strings.CutSuffix(rel, "/..."); ok {
+			f.logf("    Matching '%s' against '%s' in module %s", prefix, relImportPath, f.modulePath)
+			return relImportPath == prefix || strings.HasPrefix(relImportPath, prefix+"/")
+		}
+		f.logf("    Matching '%s' against '%s' in module %s", rel, relImportPath, f.modulePath)
+		return relImportPath == rel
+	}
 
-	log.Printf("    Matching pattern '%s' against import '%s' and dir '%s'", pattern, importPath, dir)
+	f.logf("    Matching pattern '%s' against import '%s' and dir '%s'", pattern, importPath, dir)
 
 	// First check exact match against import path
 	if pattern == importPath {
-		log.Printf("    -> Matched exact import path")
+		f.logf("    -> Matched exact import path")
 		return true
 	}
 
 	// Handle wildcards
 	if strings.HasSuffix(pattern, "/...") {
 		prefix := strings.TrimSuffix(pattern, "/...")
-		log.Printf("    Checking wildcard with prefix '%s'", prefix)
+		f.logf("    Checking wildcard with prefix '%s'", prefix)
 
 		if prefix == "." {
-			log.Printf("    -> Matched '.' wildcard")
+			f.logf("    -> Matched '.' wildcard")
 			return true // Match everything for "./..."
 		}
 
 		// Check if the package path ends with the prefix
 		if strings.HasSuffix(importPath, "/"+prefix) || strings.HasPrefix(importPath, prefix+"/") {
-			log.Printf("    -> Matched import path")
+			f.logf("    -> Matched import path")
 			return true
 		}
 
 		// Check if the directory path contains the prefix
 		if strings.Contains(dir, "/"+prefix+"/") {
-			log.Printf("    -> Matched directory path")
+			f.logf("    -> Matched directory path")
 			return true
 		}
 
-		log.Printf("    -> No wildcard matches found")
+		f.logf("    -> No wildcard matches found")
 		return false
 	}
 
 	// For exact matches, try both the full import path and the last component
 	importParts := strings.Split(importPath, "/")
 	if len(importParts) > 0 && importParts[len(importParts)-1] == pattern {
-		log.Printf("    -> Matched package name")
+		f.logf("    -> Matched package name")
 		return true
 	}
 
 	// Try matching against the full import path
 	if strings.HasSuffix(importPath, "/"+pattern) {
-		log.Printf("    -> Matched import path")
+		f.logf("    -> Matched import path")
 		return true
 	}
 
 	// Try matching against the directory path
 	if strings.HasSuffix(dir, "/"+pattern) {
-		log.Printf("    -> Matched directory path")
+		f.logf("    -> Matched directory path")
 		return true
 	}
 
-	log.Printf("    -> No exact matches found")
+	f.logf("    -> No exact matches found")
 	return false
 }
@@ -1,8 +1,19 @@
 package pkglist
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -14,26 +25,46 @@ type MockCommand struct {
 	output []byte
 	err    error
 	dir    string
+	env    []string
 }
 
 func (c *MockCommand) SetDir(dir string) {
 	c.dir = dir
 }
 
+func (c *MockCommand) SetEnv(env []string) {
+	c.env = env
+}
+
 func (c *MockCommand) Output() ([]byte, error) {
 	return c.output, c.err
 }
 
+func (c *MockCommand) CombinedOutput() ([]byte, error) {
+	return c.output, c.err
+}
+
 // MockCommander implements Commander for testing
 type MockCommander struct {
 	commands map[string]*MockCommand
+	calls    []string
 }
 
 func (c *MockCommander) Command(name string, args ...string) Command {
 	key := fmt.Sprintf("%s %v", name, args)
+	c.calls = append(c.calls, key)
 	return c.commands[key]
 }
 
+// MockDependencyObserver records dependency additions for testing
+type MockDependencyObserver struct {
+	added map[string]string
+}
+
+func (o *MockDependencyObserver) OnDependencyAdded(pkg, addedBy string) {
+	o.added[pkg] = addedBy
+}
+
 func TestFinder_FindAll(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -60,6 +91,70 @@ func TestFinder_FindAll(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "package in module",
+			jsonOutput: `
+				{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1", "Module": {"Path": "github.com/test/repo", "Dir": "/test", "GoMod": "/test/go.mod"}}
+			`,
+			wantPkgs: map[string]*Package{
+				"github.com/test/repo/pkg1": {
+					ImportPath: "github.com/test/repo/pkg1",
+					Dir:        "/test/pkg1",
+					Module:     &Module{Path: "github.com/test/repo", Dir: "/test", GoMod: "/test/go.mod"},
+					GoMod:      "/test/go.mod",
+				},
+			},
+		},
+		{
+			name: "empty import path",
+			jsonOutput: `
+				{"ImportPath": "", "Dir": "/test/broken", "GoFiles": ["broken.go"]}
+				{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1", "GoFiles": ["file1.go"]}
+			`,
+			wantPkgs: map[string]*Package{
+				"github.com/test/repo/pkg1": {
+					ImportPath: "github.com/test/repo/pkg1",
+					Dir:        "/test/pkg1",
+					GoFiles:    []string{"file1.go"},
+				},
+			},
+		},
+		{
+			name: "duplicate import path",
+			jsonOutput: `
+				{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/elsewhere/pkg1", "GoFiles": ["other.go"]}
+				{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1", "GoFiles": ["file1.go"]}
+				{"ImportPath": "github.com/test/repo/pkg2", "Dir": "/test/pkg2", "GoFiles": ["file2.go"]}
+				{"ImportPath": "github.com/test/repo/pkg2", "Dir": "/elsewhere/pkg2", "GoFiles": ["other.go"]}
+			`,
+			wantPkgs: map[string]*Package{
+				"github.com/test/repo/pkg1": {
+					ImportPath: "github.com/test/repo/pkg1",
+					Dir:        "/test/pkg1",
+					GoFiles:    []string{"file1.go"},
+				},
+				"github.com/test/repo/pkg2": {
+					ImportPath: "github.com/test/repo/pkg2",
+					Dir:        "/test/pkg2",
+					GoFiles:    []string{"file2.go"},
+				},
+			},
+		},
+		{
+			name: "external test files",
+			jsonOutput: `
+				{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1", "GoFiles": ["file1.go"], "TestGoFiles": ["file1_test.go"], "XTestGoFiles": ["export_test.go"]}
+			`,
+			wantPkgs: map[string]*Package{
+				"github.com/test/repo/pkg1": {
+					ImportPath:   "github.com/test/repo/pkg1",
+					Dir:          "/test/pkg1",
+					GoFiles:      []string{"file1.go"},
+					TestGoFiles:  []string{"file1_test.go"},
+					XTestGoFiles: []string{"export_test.go"},
+				},
+			},
+		},
 		{
 			name:       "invalid json",
 			jsonOutput: "invalid json",
@@ -144,6 +239,31 @@ func TestFinder_FilterByPatterns(t *testing.T) {
 				"github.com/test/repo/pkg/b": {},
 			},
 		},
+		{
+			name: "match by package name",
+			packages: map[string]*Package{
+				"github.com/test/repo/cmd/server": {
+					ImportPath: "github.com/test/repo/cmd/server",
+					Dir:        "/go/src/github.com/test/repo/cmd/server",
+					Name:       "main",
+				},
+				"github.com/test/repo/cmd/client": {
+					ImportPath: "github.com/test/repo/cmd/client",
+					Dir:        "/go/src/github.com/test/repo/cmd/client",
+					Name:       "main",
+				},
+				"github.com/test/repo/main": {
+					ImportPath: "github.com/test/repo/main",
+					Dir:        "/go/src/github.com/test/repo/main",
+					Name:       "app",
+				},
+			},
+			patterns: []string{"name:main"},
+			want: map[string]struct{}{
+				"github.com/test/repo/cmd/server": {},
+				"github.com/test/repo/cmd/client": {},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -165,6 +285,7 @@ func TestFinder_GetFileList(t *testing.T) {
 		packages     map[string]*Package
 		keepPackages map[string]struct{}
 		withTests    bool
+		extraFiles   []string // files present on disk but not expected in the result
 		want         []string
 	}{
 		{
@@ -297,6 +418,51 @@ func TestFinder_GetFileList(t *testing.T) {
 				"/test/pkg2/testdata/sample.txt",
 			},
 		},
+		{
+			name: "skips cgo-generated and missing files",
+			packages: map[string]*Package{
+				"pkg1": {
+					Dir:     "/test/pkg1",
+					GoFiles: []string{"main.go", "_cgo_gotypes.go", "_cgo_export.go", "missing.go"},
+				},
+			},
+			keepPackages: map[string]struct{}{
+				"pkg1": {},
+			},
+			withTests:  false,
+			extraFiles: []string{"/test/pkg1/_cgo_gotypes.go"},
+			want: []string{
+				"/test/pkg1/main.go",
+			},
+		},
+		{
+			name: "packages sharing a directory",
+			packages: map[string]*Package{
+				"pkg1": {
+					Dir:         "/test/pkg1",
+					GoFiles:     []string{"main.go"},
+					TestGoFiles: []string{"main_test.go"},
+					EmbedFiles:  []string{"data.json"},
+				},
+				"pkg1_test": {
+					Dir:          "/test/pkg1",
+					GoFiles:      []string{"main.go"},
+					XTestGoFiles: []string{"export_test.go"},
+					EmbedFiles:   []string{"data.json"},
+				},
+			},
+			keepPackages: map[string]struct{}{
+				"pkg1":      {},
+				"pkg1_test": {},
+			},
+			withTests: true,
+			want: []string{
+				"/test/pkg1/main.go",
+				"/test/pkg1/main_test.go",
+				"/test/pkg1/export_test.go",
+				"/test/pkg1/data.json",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -306,8 +472,2380 @@ func TestFinder_GetFileList(t *testing.T) {
 				fs:       afero.NewMemMapFs(),
 			}
 
-			got := f.GetFileList(tt.keepPackages, tt.withTests)
+			for _, file := range append(tt.want, tt.extraFiles...) {
+				require.NoError(t, afero.WriteFile(f.fs, file, []byte("package test"), 0644))
+			}
+
+			got := f.GetFileList(tt.keepPackages, GetFileListOptions{WithTests: tt.withTests})
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
 }
+
+func TestFinder_FilterByPatterns_Expand(t *testing.T) {
+	packages := map[string]*Package{
+		"github.com/org/repo/op-node": {
+			ImportPath: "github.com/org/repo/op-node",
+			Dir:        "/src/repo/op-node",
+		},
+		"github.com/org/repo/legacy": {
+			ImportPath: "github.com/org/repo/legacy",
+			Dir:        "/src/repo/legacy/op-node",
+		},
+		"github.com/org/repo/op-batcher": {
+			ImportPath: "github.com/org/repo/op-batcher",
+			Dir:        "/src/repo/op-batcher",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expand   bool
+		patterns []string
+		want     map[string]struct{}
+	}{
+		{
+			name:     "without expansion short pattern also matches directories",
+			expand:   false,
+			patterns: []string{"op-node"},
+			want: map[string]struct{}{
+				"github.com/org/repo/op-node": {},
+				"github.com/org/repo/legacy":  {},
+			},
+		},
+		{
+			name:     "with expansion short pattern resolves to import path",
+			expand:   true,
+			patterns: []string{"op-node"},
+			want: map[string]struct{}{
+				"github.com/org/repo/op-node": {},
+			},
+		},
+		{
+			name:     "verbatim import path is left alone",
+			expand:   true,
+			patterns: []string{"github.com/org/repo/op-batcher"},
+			want: map[string]struct{}{
+				"github.com/org/repo/op-batcher": {},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Finder{
+				packages:       packages,
+				fs:             afero.NewMemMapFs(),
+				expandPatterns: tt.expand,
+			}
+
+			got := f.FilterByPatterns(tt.patterns)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFinder_ExpandPattern(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/org/repo/op-node":        {ImportPath: "github.com/org/repo/op-node"},
+			"github.com/org/repo/cmd/op-node":    {ImportPath: "github.com/org/repo/cmd/op-node"},
+			"github.com/org/repo/op-node/client": {ImportPath: "github.com/org/repo/op-node/client"},
+		},
+	}
+
+	assert.Equal(t, []string{"github.com/org/repo/cmd/op-node", "github.com/org/repo/op-node"}, f.expandPattern("op-node"))
+	assert.Equal(t, []string{"op-node/..."}, f.expandPattern("op-node/..."))
+	assert.Equal(t, []string{"unknown"}, f.expandPattern("unknown"))
+}
+
+func TestFinder_FilterByGlobs(t *testing.T) {
+	packages := map[string]*Package{
+		"github.com/test/repo/cmd/tool": {
+			ImportPath: "github.com/test/repo/cmd/tool",
+			Dir:        "/src/cmd/tool",
+		},
+		"github.com/test/repo/svc/cmd/server": {
+			ImportPath: "github.com/test/repo/svc/cmd/server",
+			Dir:        "/src/svc/cmd/server",
+		},
+		"github.com/test/repo/pkg/util": {
+			ImportPath: "github.com/test/repo/pkg/util",
+			Dir:        "/src/pkg/util",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     map[string]struct{}
+	}{
+		{
+			name:     "double star matches at any depth",
+			patterns: []string{"**/cmd/**"},
+			want: map[string]struct{}{
+				"github.com/test/repo/cmd/tool":       {},
+				"github.com/test/repo/svc/cmd/server": {},
+			},
+		},
+		{
+			name:     "single star matches one segment",
+			patterns: []string{"pkg/*"},
+			want: map[string]struct{}{
+				"github.com/test/repo/pkg/util": {},
+			},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"internal/**"},
+			want:     map[string]struct{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Finder{
+				sourceDir: "/src",
+				packages:  packages,
+				fs:        afero.NewMemMapFs(),
+			}
+
+			got := f.FilterByGlobs(tt.patterns)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFinder_FilterByNegation(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/foo/a": {
+				ImportPath: "github.com/test/repo/foo/a",
+				Dir:        "/src/foo/a",
+			},
+			"github.com/test/repo/foo/b": {
+				ImportPath: "github.com/test/repo/foo/b",
+				Dir:        "/src/foo/b",
+			},
+			"github.com/test/repo/bar": {
+				ImportPath: "github.com/test/repo/bar",
+				Dir:        "/src/bar",
+			},
+		},
+		fs: afero.NewMemMapFs(),
+	}
+
+	patterns := []string{"./...", "!foo/..."}
+	keep := map[string]struct{}{
+		"github.com/test/repo/foo/a": {},
+		"github.com/test/repo/foo/b": {},
+		"github.com/test/repo/bar":   {},
+	}
+
+	f.FilterByNegation(keep, patterns)
+	assert.Equal(t, map[string]struct{}{
+		"github.com/test/repo/bar": {},
+	}, keep)
+	assert.Equal(t, map[string]string{
+		"github.com/test/repo/foo/a": "!foo/...",
+		"github.com/test/repo/foo/b": "!foo/...",
+	}, f.RemovedPackages())
+}
+
+func TestFinder_FilterByPatterns_Negation(t *testing.T) {
+	packages := map[string]*Package{
+		"github.com/test/repo/cmd/app": {
+			ImportPath: "github.com/test/repo/cmd/app",
+			Dir:        "/src/cmd/app",
+		},
+		"github.com/test/repo/internal/experimental": {
+			ImportPath: "github.com/test/repo/internal/experimental",
+			Dir:        "/src/internal/experimental",
+		},
+		"github.com/test/repo/internal/experimental/flags": {
+			ImportPath: "github.com/test/repo/internal/experimental/flags",
+			Dir:        "/src/internal/experimental/flags",
+		},
+		"github.com/test/repo/internal/store": {
+			ImportPath: "github.com/test/repo/internal/store",
+			Dir:        "/src/internal/store",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{
+			name:     "exclude subtree",
+			patterns: []string{"./...", "!internal/experimental/..."},
+			want:     []string{"github.com/test/repo/cmd/app", "github.com/test/repo/internal/store"},
+		},
+		{
+			name:     "negation before positive patterns",
+			patterns: []string{"!internal/experimental/...", "./..."},
+			want:     []string{"github.com/test/repo/cmd/app", "github.com/test/repo/internal/store"},
+		},
+		{
+			name:     "negation wins over an explicit match",
+			patterns: []string{"internal/...", "internal/experimental/flags", "!internal/experimental/flags"},
+			want:     []string{"github.com/test/repo/internal/experimental", "github.com/test/repo/internal/store"},
+		},
+		{
+			name:     "overlapping negations",
+			patterns: []string{"./...", "!internal/...", "!internal/experimental/..."},
+			want:     []string{"github.com/test/repo/cmd/app"},
+		},
+		{
+			name:     "only negations",
+			patterns: []string{"!cmd/app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Finder{packages: packages, fs: afero.NewMemMapFs()}
+			keep := f.FilterByPatterns(tt.patterns)
+
+			var got []string
+			for pkg := range keep {
+				got = append(got, pkg)
+			}
+			sort.Strings(got)
+			assert.Equal(t, tt.want, got)
+			for _, pkg := range tt.want {
+				assert.NotContains(t, f.RemovedPackages(), pkg)
+			}
+		})
+	}
+}
+
+func TestFinder_TopologicalSort(t *testing.T) {
+	t.Run("acyclic", func(t *testing.T) {
+		f := &Finder{
+			packages: map[string]*Package{
+				"a": {ImportPath: "a", Deps: []string{"b", "c", "fmt"}},
+				"b": {ImportPath: "b", Deps: []string{"c"}},
+				"c": {ImportPath: "c"},
+			},
+		}
+
+		got, err := f.TopologicalSort(map[string]struct{}{"a": {}, "b": {}, "c": {}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c", "b", "a"}, got)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		f := &Finder{
+			packages: map[string]*Package{
+				"a": {ImportPath: "a", Deps: []string{"b"}},
+				"b": {ImportPath: "b", Deps: []string{"c"}},
+				"c": {ImportPath: "c", Deps: []string{"a"}},
+			},
+		}
+
+		_, err := f.TopologicalSort(map[string]struct{}{"a": {}, "b": {}, "c": {}})
+		require.Error(t, err)
+
+		var cycleErr *CircularDepError
+		require.True(t, errors.As(err, &cycleErr))
+		assert.Equal(t, []string{"a", "b", "c"}, cycleErr.Cycle)
+		assert.Equal(t, "circular dependency: a -> b -> c -> a", err.Error())
+	})
+}
+
+func TestFinder_AddDependencies_ExcludeCrossModuleInternal(t *testing.T) {
+	packages := map[string]*Package{
+		"github.com/test/repo/app": {
+			ImportPath: "github.com/test/repo/app",
+			Imports:    []string{"github.com/other/module/internal/util", "github.com/test/repo/internal/config"},
+			Deps:       []string{"github.com/other/module/internal/util", "github.com/test/repo/internal/config"},
+		},
+		"github.com/test/repo/internal/config": {
+			ImportPath: "github.com/test/repo/internal/config",
+		},
+		"github.com/other/module/internal/util": {
+			ImportPath: "github.com/other/module/internal/util",
+		},
+		"github.com/other/module/pub": {
+			ImportPath: "github.com/other/module/pub",
+			Imports:    []string{"github.com/other/module/internal/util"},
+			Deps:       []string{"github.com/other/module/internal/util"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		exclude bool
+		roots   []string
+		want    map[string]struct{}
+	}{
+		{
+			name:  "disabled keeps every dependency",
+			roots: []string{"github.com/test/repo/app"},
+			want: map[string]struct{}{
+				"github.com/test/repo/app":              {},
+				"github.com/test/repo/internal/config":  {},
+				"github.com/other/module/internal/util": {},
+			},
+		},
+		{
+			name:    "enabled skips disallowed internal import",
+			exclude: true,
+			roots:   []string{"github.com/test/repo/app"},
+			want: map[string]struct{}{
+				"github.com/test/repo/app":             {},
+				"github.com/test/repo/internal/config": {},
+			},
+		},
+		{
+			name:    "enabled keeps allowed internal import",
+			exclude: true,
+			roots:   []string{"github.com/other/module/pub"},
+			want: map[string]struct{}{
+				"github.com/other/module/pub":           {},
+				"github.com/other/module/internal/util": {},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Finder{
+				packages:                   packages,
+				excludeCrossModuleInternal: tt.exclude,
+			}
+
+			keep := make(map[string]struct{})
+			for _, root := range tt.roots {
+				keep[root] = struct{}{}
+			}
+			transitive := f.GetTransitiveDeps(keep)
+			f.AddDependencies(keep)
+			assert.Equal(t, tt.want, keep)
+
+			// GetTransitiveDeps and TransitiveDepsOf must agree with AddDependencies
+			want := make([]string, 0, len(tt.want))
+			for pkg := range tt.want {
+				want = append(want, pkg)
+			}
+			sort.Strings(want)
+			assert.Equal(t, want, transitive)
+
+			var deps []string
+			for _, p := range f.TransitiveDepsOf(tt.roots[0]) {
+				deps = append(deps, p.ImportPath)
+			}
+			assert.ElementsMatch(t, slices.DeleteFunc(want, func(pkg string) bool {
+				return pkg == tt.roots[0]
+			}), deps)
+		})
+	}
+}
+
+func TestFinder_FindOrphans(t *testing.T) {
+	// Diamond: a -> {b, c}, b -> d, c -> d
+	packages := map[string]*Package{
+		"github.com/test/repo/a": {
+			ImportPath: "github.com/test/repo/a",
+			Dir:        "/src/a",
+			Deps:       []string{"github.com/test/repo/b", "github.com/test/repo/c", "github.com/test/repo/d"},
+		},
+		"github.com/test/repo/b": {
+			ImportPath: "github.com/test/repo/b",
+			Dir:        "/src/b",
+			Deps:       []string{"github.com/test/repo/d"},
+		},
+		"github.com/test/repo/c": {
+			ImportPath: "github.com/test/repo/c",
+			Dir:        "/src/c",
+			Deps:       []string{"github.com/test/repo/d"},
+		},
+		"github.com/test/repo/d": {
+			ImportPath: "github.com/test/repo/d",
+			Dir:        "/src/d",
+		},
+	}
+
+	f := &Finder{
+		packages: packages,
+		fs:       afero.NewMemMapFs(),
+	}
+
+	keep := f.FilterByPatterns([]string{"github.com/test/repo/a"})
+	f.AddDependencies(keep)
+	assert.Len(t, keep, 4)
+	assert.Empty(t, f.FindOrphans())
+
+	// Remove the b branch of the diamond: b is now stale, d is still reachable through c
+	packages["github.com/test/repo/a"].Deps = []string{"github.com/test/repo/c", "github.com/test/repo/d"}
+
+	orphans := f.FindOrphans()
+	require.Len(t, orphans, 1)
+	assert.Equal(t, "github.com/test/repo/b", orphans[0].ImportPath)
+}
+
+func TestFinder_DepthReport(t *testing.T) {
+	// Chain: a -> b -> c -> d -> e, with a shortcut a -> c and an unrelated f
+	chain := []string{"a", "b", "c", "d", "e"}
+	packages := make(map[string]*Package)
+	for i, name := range chain {
+		p := &Package{
+			ImportPath: "github.com/test/repo/" + name,
+			Dir:        "/src/" + name,
+		}
+		for _, dep := range chain[i+1:] {
+			p.Deps = append(p.Deps, "github.com/test/repo/"+dep)
+		}
+		if i+1 < len(chain) {
+			p.Imports = []string{"github.com/test/repo/" + chain[i+1]}
+		}
+		packages[p.ImportPath] = p
+	}
+	packages["github.com/test/repo/a"].Imports = append(packages["github.com/test/repo/a"].Imports, "github.com/test/repo/c", "fmt")
+	packages["github.com/test/repo/f"] = &Package{ImportPath: "github.com/test/repo/f", Dir: "/src/f"}
+
+	f := &Finder{
+		packages: packages,
+		fs:       afero.NewMemMapFs(),
+	}
+
+	keep := f.FilterByPatterns([]string{"github.com/test/repo/a"})
+	f.AddDependencies(keep)
+	assert.Equal(t, map[string]int{
+		"github.com/test/repo/a": 0,
+		"github.com/test/repo/b": 1,
+		"github.com/test/repo/c": 1,
+		"github.com/test/repo/d": 2,
+		"github.com/test/repo/e": 3,
+	}, f.DepthReport(keep))
+
+	// Without the shortcut every link in the chain adds a level
+	packages["github.com/test/repo/a"].Imports = []string{"github.com/test/repo/b"}
+	keep["github.com/test/repo/f"] = struct{}{}
+	assert.Equal(t, map[string]int{
+		"github.com/test/repo/a": 0,
+		"github.com/test/repo/b": 1,
+		"github.com/test/repo/c": 2,
+		"github.com/test/repo/d": 3,
+		"github.com/test/repo/e": 4,
+		"github.com/test/repo/f": -1,
+	}, f.DepthReport(keep))
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...any) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func TestFinder_WithLogger(t *testing.T) {
+	packages := map[string]*Package{
+		"github.com/test/repo/pkg1": {ImportPath: "github.com/test/repo/pkg1", Dir: "/src/pkg1"},
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	logger := &recordingLogger{}
+	f := NewFinder("/src", WithLogger(logger))
+	f.packages = packages
+	f.FilterByPatterns([]string{"github.com/test/repo/pkg1"})
+	require.Len(t, logger.messages, 5)
+	assert.Equal(t, []string{
+		"Processing pattern: github.com/test/repo/pkg1",
+		"    Matching pattern 'github.com/test/repo/pkg1' against import 'github.com/test/repo/pkg1' and dir '/src/pkg1'",
+		"    -> Matched exact import path",
+		"  Matched package: github.com/test/repo/pkg1 at /src/pkg1",
+	}, logger.messages[:4])
+	assert.Regexp(t, `^Finder phase FilterByPatterns took \S+$`, logger.messages[4])
+
+	f = NewFinder("/src", WithLogger(NopLogger{}))
+	f.packages = packages
+	f.FilterByPatterns([]string{"github.com/test/repo/pkg1"})
+	assert.Empty(t, logs.String())
+
+	// Without a logger, messages go to the standard logger
+	f = &Finder{packages: packages, fs: afero.NewMemMapFs()}
+	f.FilterByPatterns([]string{"github.com/test/repo/pkg1"})
+	assert.Contains(t, logs.String(), "Processing pattern: github.com/test/repo/pkg1")
+}
+
+func TestFinder_GetTransitiveDeps(t *testing.T) {
+	// Diamond: a -> {b, c}, b -> d, c -> d, d -> e; f is unrelated
+	packages := map[string]*Package{
+		"github.com/test/repo/a": {
+			ImportPath: "github.com/test/repo/a",
+			Dir:        "/src/a",
+			Deps:       []string{"fmt", "github.com/test/repo/b", "github.com/test/repo/c", "github.com/test/repo/d", "github.com/test/repo/e"},
+		},
+		"github.com/test/repo/b": {
+			ImportPath: "github.com/test/repo/b",
+			Dir:        "/src/b",
+			Deps:       []string{"github.com/test/repo/d", "github.com/test/repo/e"},
+		},
+		"github.com/test/repo/c": {
+			ImportPath: "github.com/test/repo/c",
+			Dir:        "/src/c",
+			Deps:       []string{"github.com/test/repo/d", "github.com/test/repo/e", "strings"},
+		},
+		"github.com/test/repo/d": {
+			ImportPath: "github.com/test/repo/d",
+			Dir:        "/src/d",
+			Deps:       []string{"github.com/test/repo/e"},
+		},
+		"github.com/test/repo/e": {
+			ImportPath: "github.com/test/repo/e",
+			Dir:        "/src/e",
+		},
+		"github.com/test/repo/f": {
+			ImportPath: "github.com/test/repo/f",
+			Dir:        "/src/f",
+		},
+	}
+	f := &Finder{packages: packages, fs: afero.NewMemMapFs()}
+
+	tests := []struct {
+		name  string
+		roots PkgSet
+		want  []string
+	}{
+		{
+			name:  "diamond top",
+			roots: PkgSet{"github.com/test/repo/a": {}},
+			want:  []string{"github.com/test/repo/a", "github.com/test/repo/b", "github.com/test/repo/c", "github.com/test/repo/d", "github.com/test/repo/e"},
+		},
+		{
+			name:  "both sides of the diamond",
+			roots: PkgSet{"github.com/test/repo/b": {}, "github.com/test/repo/c": {}},
+			want:  []string{"github.com/test/repo/b", "github.com/test/repo/c", "github.com/test/repo/d", "github.com/test/repo/e"},
+		},
+		{
+			name:  "leaf and unrelated package",
+			roots: PkgSet{"github.com/test/repo/e": {}, "github.com/test/repo/f": {}},
+			want:  []string{"github.com/test/repo/e", "github.com/test/repo/f"},
+		},
+		{
+			name:  "roots outside the repository",
+			roots: PkgSet{"fmt": {}},
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots := make(PkgSet, len(tt.roots))
+			for pkg := range tt.roots {
+				roots[pkg] = struct{}{}
+			}
+			assert.Equal(t, tt.want, f.GetTransitiveDeps(roots))
+			assert.Equal(t, tt.roots, roots, "roots are not modified")
+
+			// Agrees with AddDependencies
+			f.AddDependencies(roots)
+			want := make([]string, 0, len(roots))
+			for pkg := range roots {
+				if _, inRepo := packages[pkg]; inRepo {
+					want = append(want, pkg)
+				}
+			}
+			sort.Strings(want)
+			assert.Equal(t, want, tt.want)
+		})
+	}
+}
+
+func TestFinder_Metrics(t *testing.T) {
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"go [list -json ./...]": {
+				output: []byte(`{"ImportPath": "github.com/test/repo/cmd", "Dir": "/test/cmd", "GoFiles": ["main.go", "flags.go"], "TestGoFiles": ["main_test.go"], "Deps": ["fmt", "github.com/test/repo/lib", "github.com/test/repo/lib/internal"]}
+{"ImportPath": "github.com/test/repo/lib", "Dir": "/test/lib", "GoFiles": ["lib.go"], "CgoFiles": ["cgo.go"], "XTestGoFiles": ["lib_test.go"], "OtherFiles": ["README.md"], "Deps": ["github.com/test/repo/lib/internal"]}
+{"ImportPath": "github.com/test/repo/lib/internal", "Dir": "/test/lib/internal", "GoFiles": ["internal.go"]}`),
+			},
+		},
+	}
+
+	f := &Finder{
+		sourceDir: "/test",
+		packages:  make(map[string]*Package),
+		fs:        afero.NewMemMapFs(),
+		commander: commander,
+	}
+	assert.Equal(t, FinderMetrics{}, f.Metrics())
+
+	require.NoError(t, f.FindAll())
+	assert.Equal(t, FinderMetrics{
+		PackageCount:        3,
+		FileCount:           7,
+		DependencyEdgeCount: 4,
+	}, f.Metrics())
+}
+
+func TestFinder_Timing(t *testing.T) {
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"go [list -json ./...]": {
+				output: []byte(`{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1", "GoFiles": ["file1.go"]}`),
+			},
+		},
+	}
+
+	f := &Finder{
+		sourceDir: "/test",
+		packages:  make(map[string]*Package),
+		fs:        afero.NewMemMapFs(),
+		commander: commander,
+	}
+
+	require.NoError(t, f.FindAll())
+	keep := f.FilterByPatterns([]string{"./..."})
+	f.AddDependencies(keep)
+	f.GetFileList(keep, GetFileListOptions{})
+
+	timing := f.Timing()
+	for _, phase := range []string{"FindAll", "FilterByPatterns", "AddDependencies", "GetFileList"} {
+		d, ok := timing[phase]
+		assert.True(t, ok, "phase %s not reported", phase)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+	}
+}
+
+func TestFinder_FilterByPatterns_Anchored(t *testing.T) {
+	module := &Module{Path: "github.com/org/repo"}
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/org/repo/op-node": {
+				ImportPath: "github.com/org/repo/op-node",
+				Dir:        "/src/op-node",
+				Module:     module,
+			},
+			"github.com/org/repo/op-node/p2p": {
+				ImportPath: "github.com/org/repo/op-node/p2p",
+				Dir:        "/src/op-node/p2p",
+				Module:     module,
+			},
+			"github.com/org/repo/tools/op-node": {
+				ImportPath: "github.com/org/repo/tools/op-node",
+				Dir:        "/src/tools/op-node",
+				Module:     module,
+			},
+			"github.com/org/repo/op-nodes": {
+				ImportPath: "github.com/org/repo/op-nodes",
+				Dir:        "/src/op-nodes",
+				Module:     module,
+			},
+		},
+		fs: afero.NewMemMapFs(),
+	}
+
+	want := map[string]struct{}{
+		"github.com/org/repo/op-node":     {},
+		"github.com/org/repo/op-node/p2p": {},
+	}
+	assert.Equal(t, want, f.FilterByPatterns([]string{"^op-node"}))
+	assert.Equal(t, want, f.FilterByPatterns([]string{"^op-node/..."}))
+
+	// Unanchored, the nested tools/op-node also matches
+	assert.Contains(t, f.FilterByPatterns([]string{"op-node"}), "github.com/org/repo/tools/op-node")
+}
+
+func TestFinder_AddDependencies_Observer(t *testing.T) {
+	obs := &MockDependencyObserver{added: make(map[string]string)}
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/a": {
+				ImportPath: "github.com/test/repo/a",
+				Deps:       []string{"fmt", "github.com/test/repo/b"},
+			},
+			"github.com/test/repo/b": {
+				ImportPath: "github.com/test/repo/b",
+				Deps:       []string{"github.com/test/repo/c"},
+			},
+			"github.com/test/repo/c": {
+				ImportPath: "github.com/test/repo/c",
+			},
+		},
+		depObserver: obs,
+	}
+
+	f.AddDependencies(map[string]struct{}{"github.com/test/repo/a": {}})
+	assert.Equal(t, map[string]string{
+		"github.com/test/repo/b": "github.com/test/repo/a",
+		"github.com/test/repo/c": "github.com/test/repo/b",
+	}, obs.added)
+}
+
+func TestFinder_FindAll_AutoDownload(t *testing.T) {
+	listOutput := `{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1"}`
+
+	t.Run("download then list", func(t *testing.T) {
+		commander := &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [mod download -json]": {output: []byte(`{"Path": "github.com/dep/mod", "Version": "v1.0.0"}`)},
+				"go [list -json ./...]":   {output: []byte(listOutput)},
+			},
+		}
+		f := &Finder{
+			sourceDir:    "/test",
+			packages:     make(map[string]*Package),
+			commander:    commander,
+			autoDownload: true,
+		}
+
+		require.NoError(t, f.FindAll())
+		assert.Equal(t, []string{"go [mod download -json]", "go [list -json ./...]"}, commander.calls)
+		assert.Contains(t, f.packages, "github.com/test/repo/pkg1")
+	})
+
+	t.Run("download failure", func(t *testing.T) {
+		commander := &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [mod download -json]": {
+					output: []byte(`{"Path": "github.com/dep/mod", "Version": "v1.0.0", "Error": "not found"}`),
+					err:    errors.New("exit status 1"),
+				},
+			},
+		}
+		f := &Finder{
+			sourceDir:    "/test",
+			packages:     make(map[string]*Package),
+			commander:    commander,
+			autoDownload: true,
+		}
+
+		err := f.FindAll()
+		var dlErr *DownloadError
+		require.True(t, errors.As(err, &dlErr))
+		assert.Equal(t, []string{"github.com/dep/mod@v1.0.0: not found"}, dlErr.Modules)
+		assert.Equal(t, []string{"go [mod download -json]"}, commander.calls)
+	})
+}
+
+func TestFinder_FilterByNameFunc(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/one": {
+				ImportPath: "github.com/test/repo/one",
+				GoFiles:    []string{"one.go"},
+			},
+			"github.com/test/repo/two": {
+				ImportPath: "github.com/test/repo/two",
+				GoFiles:    []string{"a.go", "b.go"},
+			},
+			"github.com/test/repo/single": {
+				ImportPath: "github.com/test/repo/single",
+				GoFiles:    []string{"single.go"},
+			},
+		},
+	}
+
+	got := f.FilterByNameFunc(func(pkg *Package) bool {
+		return len(pkg.GoFiles) == 1
+	})
+	assert.Equal(t, map[string]struct{}{
+		"github.com/test/repo/one":    {},
+		"github.com/test/repo/single": {},
+	}, got)
+}
+
+func TestFinder_FilterByPatterns_NameFilter(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/cmd/tool": {
+				ImportPath: "github.com/test/repo/cmd/tool",
+				Dir:        "/src/cmd/tool",
+				Name:       "main",
+			},
+			"github.com/test/repo/cmd/internal": {
+				ImportPath: "github.com/test/repo/cmd/internal",
+				Dir:        "/src/cmd/internal",
+				Name:       "internal",
+			},
+		},
+		fs: afero.NewMemMapFs(),
+		nameFilter: func(name string) bool {
+			return name == "main"
+		},
+	}
+
+	got := f.FilterByPatterns([]string{"./..."})
+	assert.Equal(t, map[string]struct{}{
+		"github.com/test/repo/cmd/tool": {},
+	}, got)
+}
+
+func TestFinder_PackagesByDir(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/pkg1_test": {
+				ImportPath: "github.com/test/repo/pkg1_test",
+				Dir:        "/src/pkg1",
+			},
+			"github.com/test/repo/pkg1": {
+				ImportPath: "github.com/test/repo/pkg1",
+				Dir:        "/src/pkg1",
+			},
+			"github.com/test/repo/pkg2": {
+				ImportPath: "github.com/test/repo/pkg2",
+				Dir:        "/src/pkg2",
+			},
+		},
+	}
+
+	byDir := f.PackagesByDir()
+	require.Len(t, byDir, 2)
+	require.Len(t, byDir["/src/pkg1"], 2)
+	assert.Equal(t, "github.com/test/repo/pkg1", byDir["/src/pkg1"][0].ImportPath)
+	assert.Equal(t, "github.com/test/repo/pkg1_test", byDir["/src/pkg1"][1].ImportPath)
+	require.Len(t, byDir["/src/pkg2"], 1)
+}
+
+func TestFinder_FindAll_Platform(t *testing.T) {
+	t.Run("valid platform", func(t *testing.T) {
+		listCmd := &MockCommand{output: []byte(`{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1"}`)}
+		f := NewFinder("/test", WithPlatform("linux", "arm64"))
+		f.commander = &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [list -json ./...]": listCmd,
+			},
+		}
+
+		require.NoError(t, f.FindAll())
+		assert.Equal(t, []string{"GOOS=linux", "GOARCH=arm64"}, listCmd.env)
+	})
+
+	t.Run("separate options", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			opts    []Option
+			wantEnv []string
+		}{
+			{name: "goos", opts: []Option{WithGoOS("darwin")}, wantEnv: []string{"GOOS=darwin"}},
+			{name: "goarch", opts: []Option{WithGoArch("arm64")}, wantEnv: []string{"GOARCH=arm64"}},
+			{name: "both", opts: []Option{WithGoOS("windows"), WithGoArch("amd64")}, wantEnv: []string{"GOOS=windows", "GOARCH=amd64"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				listCmd := &MockCommand{output: []byte(`{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1"}`)}
+				f := NewFinder("/test", tt.opts...)
+				f.commander = &MockCommander{
+					commands: map[string]*MockCommand{
+						"go [list -json ./...]": listCmd,
+					},
+				}
+
+				require.NoError(t, f.FindAll())
+				assert.Equal(t, tt.wantEnv, listCmd.env)
+			})
+		}
+	})
+
+	t.Run("real command environment", func(t *testing.T) {
+		f := NewFinder("/test", WithGoOS("darwin"), WithGoArch("arm64"))
+		env, err := f.commandEnv()
+		require.NoError(t, err)
+
+		cmd := (&RealCommander{}).Command("go", "list")
+		cmd.SetEnv(env)
+		realEnv := cmd.(*RealCommand).cmd.Env
+		assert.Contains(t, realEnv, "GOOS=darwin")
+		assert.Contains(t, realEnv, "GOARCH=arm64")
+	})
+
+	t.Run("invalid platform", func(t *testing.T) {
+		commander := &MockCommander{}
+		f := NewFinder("/test", WithPlatform("fakeos", "fakearch"))
+		f.commander = commander
+
+		err := f.FindAll()
+		assert.EqualError(t, err, "unsupported platform fakeos/fakearch")
+		assert.Empty(t, commander.calls)
+	})
+}
+
+func TestFinder_IgnoreReplace(t *testing.T) {
+	const goMod = `module github.com/test/repo
+
+go 1.22
+
+require example.com/lib v1.2.0
+
+replace example.com/lib => ./lib
+
+replace example.com/other v1.0.0 => example.com/fork v1.0.1
+`
+
+	t.Run("go list uses go.mod without replace directives", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/test/go.mod", []byte(goMod), 0644))
+		require.NoError(t, afero.WriteFile(fs, "/test/go.sum", []byte("example.com/lib v1.2.0 h1:abc=\n"), 0644))
+
+		listCmd := &MockCommand{output: []byte(`{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1"}`)}
+		f := NewFinder("/test", WithIgnoreReplace(true))
+		f.fs = fs
+		f.commander = &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [list -json ./...]": listCmd,
+			},
+		}
+
+		require.NoError(t, f.FindAll())
+		require.Len(t, listCmd.env, 1)
+		goflags, ok := strings.CutPrefix(listCmd.env[0], "GOFLAGS=-mod=mod -modfile=")
+		require.True(t, ok, listCmd.env[0])
+		assert.Equal(t, "go.mod", filepath.Base(goflags))
+
+		// The temporary go.mod is removed once go list is done
+		exists, err := afero.Exists(fs, filepath.Dir(goflags))
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("replace directives are dropped", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, "/test/go.mod", []byte(goMod), 0644))
+		require.NoError(t, afero.WriteFile(fs, "/test/go.sum", []byte("example.com/lib v1.2.0 h1:abc=\n"), 0644))
+
+		f := &Finder{sourceDir: "/test", fs: fs}
+		modFile, err := f.writeModFileWithoutReplace()
+		require.NoError(t, err)
+
+		data, err := afero.ReadFile(fs, modFile)
+		require.NoError(t, err)
+		assert.Equal(t, "module github.com/test/repo\n\ngo 1.22\n\nrequire example.com/lib v1.2.0\n", string(data))
+
+		sum, err := afero.ReadFile(fs, filepath.Join(filepath.Dir(modFile), "go.sum"))
+		require.NoError(t, err)
+		assert.Equal(t, "example.com/lib v1.2.0 h1:abc=\n", string(sum))
+
+		// The original go.mod is untouched
+		data, err = afero.ReadFile(fs, "/test/go.mod")
+		require.NoError(t, err)
+		assert.Equal(t, goMod, string(data))
+	})
+
+	t.Run("missing go.mod", func(t *testing.T) {
+		commander := &MockCommander{}
+		f := NewFinder("/test", WithIgnoreReplace(true))
+		f.fs = afero.NewMemMapFs()
+		f.commander = commander
+
+		err := f.FindAll()
+		assert.ErrorContains(t, err, "failed to drop replace directives")
+		assert.Empty(t, commander.calls)
+	})
+}
+
+func TestFinder_GenerateWorkFile(t *testing.T) {
+	newFinder := func() *Finder {
+		return &Finder{
+			sourceDir: "/src",
+			fs:        afero.NewMemMapFs(),
+			packages: map[string]*Package{
+				"example.com/api/server": {
+					ImportPath: "example.com/api/server",
+					Dir:        "/src/api/server",
+					GoMod:      "/src/api/go.mod",
+					Module:     &Module{Path: "example.com/api", GoVersion: "1.21"},
+				},
+				"example.com/api/client": {
+					ImportPath: "example.com/api/client",
+					Dir:        "/src/api/client",
+					GoMod:      "/src/api/go.mod",
+					Module:     &Module{Path: "example.com/api", GoVersion: "1.21"},
+				},
+				"example.com/lib": {
+					ImportPath: "example.com/lib",
+					Dir:        "/src/lib",
+					GoMod:      "/src/lib/go.mod",
+					Module:     &Module{Path: "example.com/lib", GoVersion: "1.22.1"},
+				},
+				"example.com/legacy": {
+					ImportPath: "example.com/legacy",
+					Dir:        "/src/legacy",
+					GoMod:      "/src/legacy/go.mod",
+					Module:     &Module{Path: "example.com/legacy", GoVersion: "1.23"},
+				},
+				"golang.org/x/mod/modfile": {
+					ImportPath: "golang.org/x/mod/modfile",
+					Dir:        "/gopath/pkg/mod/golang.org/x/mod/modfile",
+					GoMod:      "/gopath/pkg/mod/golang.org/x/mod/go.mod",
+					Module:     &Module{Path: "golang.org/x/mod", GoVersion: "1.24"},
+				},
+			},
+		}
+	}
+
+	t.Run("both modules kept", func(t *testing.T) {
+		f := newFinder()
+		keep := PkgSet{"example.com/api/server": {}, "example.com/lib": {}, "golang.org/x/mod/modfile": {}}
+		require.NoError(t, f.GenerateWorkFile(keep, "/src/go.work"))
+
+		data, err := afero.ReadFile(f.fs, "/src/go.work")
+		require.NoError(t, err)
+		assert.Equal(t, "go 1.22.1\n\nuse (\n\t./api\n\t./lib\n)\n", string(data))
+	})
+
+	t.Run("one module kept", func(t *testing.T) {
+		f := newFinder()
+		keep := PkgSet{"example.com/api/client": {}}
+		require.NoError(t, f.GenerateWorkFile(keep, "/out/go.work"))
+
+		data, err := afero.ReadFile(f.fs, "/out/go.work")
+		require.NoError(t, err)
+		assert.Equal(t, "go 1.21\n\nuse ./api\n", string(data))
+	})
+
+	t.Run("no module kept", func(t *testing.T) {
+		f := newFinder()
+		err := f.GenerateWorkFile(PkgSet{"golang.org/x/mod/modfile": {}}, "/src/go.work")
+		assert.EqualError(t, err, "no kept package belongs to a module in /src")
+	})
+}
+
+func TestFinder_FilterByDirectory(t *testing.T) {
+	f := &Finder{
+		sourceDir: "/src",
+		fs:        afero.NewMemMapFs(),
+		packages: map[string]*Package{
+			"example.com/svc/api":      {ImportPath: "example.com/svc/api", Dir: "/src/services/api"},
+			"example.com/svc/api/v2":   {ImportPath: "example.com/svc/api/v2", Dir: "/src/services/api/v2"},
+			"example.com/svc/apigw":    {ImportPath: "example.com/svc/apigw", Dir: "/src/services/apigw"},
+			"github.com/org/lib/util":  {ImportPath: "github.com/org/lib/util", Dir: "/src/libs/util"},
+			"github.com/org/lib/other": {ImportPath: "github.com/org/lib/other", Dir: "/src/libs/other"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		dirs []string
+		want []string
+	}{
+		{
+			name: "relative directory and subdirectories",
+			dirs: []string{"services/api"},
+			want: []string{"example.com/svc/api", "example.com/svc/api/v2"},
+		},
+		{
+			name: "absolute directory",
+			dirs: []string{"/src/services/api/"},
+			want: []string{"example.com/svc/api", "example.com/svc/api/v2"},
+		},
+		{
+			name: "relative and absolute across modules",
+			dirs: []string{"./libs/util", "/src/services/apigw"},
+			want: []string{"example.com/svc/apigw", "github.com/org/lib/util"},
+		},
+		{
+			name: "whole tree",
+			dirs: []string{"."},
+			want: []string{"example.com/svc/api", "example.com/svc/api/v2", "example.com/svc/apigw", "github.com/org/lib/other", "github.com/org/lib/util"},
+		},
+		{
+			name: "outside the source directory",
+			dirs: []string{"/other", "../src2"},
+			want: nil,
+		},
+		{
+			name: "empty entries are ignored",
+			dirs: []string{"libs/util", "", " "},
+			want: []string{"github.com/org/lib/util"},
+		},
+		{
+			name: "only empty entries",
+			dirs: []string{""},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for pkg := range f.FilterByDirectory(tt.dirs) {
+				got = append(got, pkg)
+			}
+			sort.Strings(got)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFinder_ListUnused(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/a": {ImportPath: "github.com/test/repo/a", Dir: "/src/a", Deps: []string{"github.com/test/repo/b"}},
+			"github.com/test/repo/b": {ImportPath: "github.com/test/repo/b", Dir: "/src/b"},
+			"github.com/test/repo/c": {ImportPath: "github.com/test/repo/c", Dir: "/src/c"},
+			"github.com/test/repo/d": {ImportPath: "github.com/test/repo/d", Dir: "/src/d"},
+		},
+		fs: afero.NewMemMapFs(),
+	}
+
+	keep := f.FilterByPatterns([]string{"github.com/test/repo/a"})
+	f.AddDependencies(keep)
+
+	var unused []string
+	for _, pkg := range f.ListUnused(keep) {
+		unused = append(unused, pkg.ImportPath)
+	}
+	assert.Equal(t, []string{"github.com/test/repo/c", "github.com/test/repo/d"}, unused)
+
+	assert.Empty(t, f.ListUnused(PkgSet{
+		"github.com/test/repo/a": {},
+		"github.com/test/repo/b": {},
+		"github.com/test/repo/c": {},
+		"github.com/test/repo/d": {},
+	}))
+}
+
+func TestFinder_PackagesByMod(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/a/pkg2": {ImportPath: "github.com/test/a/pkg2", GoMod: "/src/a/go.mod"},
+			"github.com/test/a/pkg1": {ImportPath: "github.com/test/a/pkg1", GoMod: "/src/a/go.mod"},
+			"github.com/test/b/pkg":  {ImportPath: "github.com/test/b/pkg", GoMod: "/src/b/go.mod"},
+			"gopath/pkg":             {ImportPath: "gopath/pkg"},
+		},
+	}
+
+	byMod := f.PackagesByMod()
+	require.Len(t, byMod, 2)
+
+	var a []string
+	for _, pkg := range byMod["/src/a/go.mod"] {
+		a = append(a, pkg.ImportPath)
+	}
+	assert.Equal(t, []string{"github.com/test/a/pkg1", "github.com/test/a/pkg2"}, a)
+	require.Len(t, byMod["/src/b/go.mod"], 1)
+	assert.Equal(t, "github.com/test/b/pkg", byMod["/src/b/go.mod"][0].ImportPath)
+}
+
+func TestFinder_DepsOf(t *testing.T) {
+	// a imports b and fmt, b imports c
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/a": {
+				ImportPath: "github.com/test/repo/a",
+				Imports:    []string{"fmt", "github.com/test/repo/b"},
+				Deps:       []string{"errors", "fmt", "github.com/test/repo/b", "github.com/test/repo/c"},
+			},
+			"github.com/test/repo/b": {
+				ImportPath: "github.com/test/repo/b",
+				Imports:    []string{"github.com/test/repo/c"},
+				Deps:       []string{"github.com/test/repo/c"},
+			},
+			"github.com/test/repo/c": {
+				ImportPath: "github.com/test/repo/c",
+				Imports:    []string{"errors"},
+				Deps:       []string{"errors"},
+			},
+		},
+	}
+
+	importPaths := func(pkgs []*Package) []string {
+		var paths []string
+		for _, p := range pkgs {
+			paths = append(paths, p.ImportPath)
+		}
+		return paths
+	}
+
+	assert.Equal(t, []string{"github.com/test/repo/b"}, importPaths(f.DepsOf("github.com/test/repo/a")))
+	assert.Equal(t, []string{"github.com/test/repo/b", "github.com/test/repo/c"}, importPaths(f.TransitiveDepsOf("github.com/test/repo/a")))
+	assert.Empty(t, f.DepsOf("github.com/test/repo/c"))
+	assert.Empty(t, f.TransitiveDepsOf("github.com/test/repo/c"))
+	assert.Nil(t, f.DepsOf("github.com/test/repo/missing"))
+	assert.Nil(t, f.TransitiveDepsOf("github.com/test/repo/missing"))
+}
+
+func TestFinder_MaxFilesPerPackage(t *testing.T) {
+	files := func(n int) []string {
+		out := make([]string, n)
+		for i := range out {
+			out[i] = fmt.Sprintf("file%d.go", i)
+		}
+		return out
+	}
+
+	f := NewFinder("/src", WithMaxFilesPerPackage(50))
+	f.packages = map[string]*Package{
+		"github.com/test/repo/small": {
+			ImportPath: "github.com/test/repo/small",
+			Dir:        "/src/small",
+			GoFiles:    files(2),
+			OtherFiles: []string{"README.md"},
+		},
+		"github.com/test/repo/large": {
+			ImportPath: "github.com/test/repo/large",
+			Dir:        "/src/large",
+			GoFiles:    files(90),
+			OtherFiles: files(10),
+		},
+		"github.com/test/repo/app": {
+			ImportPath: "github.com/test/repo/app",
+			Dir:        "/src/app",
+			GoFiles:    files(1),
+			Deps:       []string{"github.com/test/repo/large", "github.com/test/repo/small"},
+		},
+	}
+
+	keep := f.FilterByPatterns([]string{"small", "large"})
+	f.AddDependencies(keep)
+	assert.Equal(t, map[string]struct{}{"github.com/test/repo/small": {}}, keep)
+
+	// The limit applies to the final keep set, not just to pattern matches
+	keep = map[string]struct{}{"github.com/test/repo/app": {}}
+	f.AddDependencies(keep)
+	assert.Equal(t, map[string]struct{}{
+		"github.com/test/repo/app":   {},
+		"github.com/test/repo/small": {},
+	}, keep)
+
+	// No limit by default
+	f.maxFiles = 0
+	keep = f.FilterByPatterns([]string{"small", "large"})
+	f.AddDependencies(keep)
+	assert.Len(t, keep, 2)
+}
+
+func TestFinder_MainPackages(t *testing.T) {
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"go [list -json ./...]": {output: []byte(`
+				{"ImportPath": "github.com/test/repo/cmd/tool", "Name": "main", "Dir": "/test/cmd/tool"}
+				{"ImportPath": "github.com/test/repo/lib", "Name": "lib", "Dir": "/test/lib"}
+				{"ImportPath": "github.com/test/repo/mainly", "Name": "mainly", "Dir": "/test/mainly"}
+				{"ImportPath": "github.com/test/repo", "Name": "main", "Dir": "/test"}
+			`)},
+		},
+	}
+	f := NewFinder("/test")
+	f.fs = afero.NewMemMapFs()
+	f.commander = commander
+	require.NoError(t, f.FindAll())
+
+	assert.True(t, f.packages["github.com/test/repo/cmd/tool"].IsMain)
+	assert.False(t, f.packages["github.com/test/repo/lib"].IsMain)
+	assert.False(t, f.packages["github.com/test/repo/mainly"].IsMain)
+
+	var mains []string
+	for _, pkg := range f.MainPackages() {
+		mains = append(mains, pkg.ImportPath)
+	}
+	assert.Equal(t, []string{"github.com/test/repo", "github.com/test/repo/cmd/tool"}, mains)
+}
+
+func TestFinder_ModuleGraph(t *testing.T) {
+	modA := &Module{Path: "github.com/test/a", Main: true}
+	modB := &Module{Path: "github.com/test/b"}
+	modC := &Module{Path: "github.com/test/c"}
+	packages := func() map[string]*Package {
+		return map[string]*Package{
+			"github.com/test/a/cmd":   {ImportPath: "github.com/test/a/cmd", Module: modA, Deps: []string{"github.com/test/b/lib"}},
+			"github.com/test/b/lib":   {ImportPath: "github.com/test/b/lib", Module: modB},
+			"github.com/test/b/other": {ImportPath: "github.com/test/b/other", Module: modB},
+			"github.com/test/c/lib":   {ImportPath: "github.com/test/c/lib", Module: modC},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		graph *MockCommand
+		want  map[string]struct{}
+	}{
+		{
+			name: "module graph",
+			graph: &MockCommand{output: []byte(
+				"github.com/test/a github.com/test/b@v1.0.0\n" +
+					"github.com/test/b@v1.0.0 golang.org/x/text@v0.3.0\n",
+			)},
+			want: map[string]struct{}{
+				"github.com/test/a/cmd":   {},
+				"github.com/test/b/lib":   {},
+				"github.com/test/b/other": {},
+			},
+		},
+		{
+			name:  "falls back to package dependencies",
+			graph: &MockCommand{err: errors.New("exit status 1")},
+			want: map[string]struct{}{
+				"github.com/test/a/cmd": {},
+				"github.com/test/b/lib": {},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFinder("/src", WithModuleGraph(true))
+			f.packages = packages()
+			f.commander = &MockCommander{
+				commands: map[string]*MockCommand{
+					"go [mod graph]": tt.graph,
+				},
+			}
+
+			keep := map[string]struct{}{"github.com/test/a/cmd": {}}
+			f.AddDependencies(keep)
+			assert.Equal(t, tt.want, keep)
+			assert.Equal(t, "/src", tt.graph.dir)
+		})
+	}
+}
+
+func TestPatternParser_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    *Pattern
+		wantErr bool
+	}{
+		{
+			name:    "plain",
+			pattern: "pkg1",
+			want:    &Pattern{Raw: "pkg1", Prefix: "pkg1"},
+		},
+		{
+			name:    "wildcard",
+			pattern: "github.com/test/repo/...",
+			want:    &Pattern{Raw: "github.com/test/repo/...", IsWildcard: true, Prefix: "github.com/test/repo"},
+		},
+		{
+			name:    "everything",
+			pattern: "./...",
+			want:    &Pattern{Raw: "./...", IsWildcard: true, Prefix: "."},
+		},
+		{
+			name:    "negated anchored wildcard",
+			pattern: "!^internal/...",
+			want:    &Pattern{Raw: "!^internal/...", IsNegation: true, IsRegex: true, IsWildcard: true, Prefix: "internal"},
+		},
+		{
+			name:    "name",
+			pattern: "name:main",
+			want:    &Pattern{Raw: "name:main", IsName: true, Prefix: "main"},
+		},
+		{name: "empty", pattern: " ", wantErr: true},
+		{name: "bare negation", pattern: "!", wantErr: true},
+		{name: "empty name", pattern: "name:", wantErr: true},
+		{name: "name with path", pattern: "name:foo/bar", wantErr: true},
+		{name: "inner wildcard", pattern: "foo/.../bar", wantErr: true},
+		{name: "double negation", pattern: "!!foo", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PatternParser{}.Parse([]string{tt.pattern})
+			if tt.wantErr {
+				assert.ErrorContains(t, err, fmt.Sprintf("invalid pattern %q", tt.pattern))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []*Pattern{tt.want}, got)
+		})
+	}
+
+	// Every invalid pattern is reported
+	_, err := PatternParser{}.Parse([]string{"name:", "pkg1", "foo/.../bar"})
+	assert.ErrorContains(t, err, `invalid pattern "name:"`)
+	assert.ErrorContains(t, err, `invalid pattern "foo/.../bar"`)
+}
+
+func TestFinder_GetFileListOptions(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, file := range []string{"/src/pkg/a.go", "/src/pkg/cgo.go", "/src/pkg/a_windows.go", "/src/pkg/a_test.go"} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte("package pkg"), 0644))
+	}
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/pkg": {
+				ImportPath:     "github.com/test/repo/pkg",
+				Dir:            "/src/pkg",
+				GoFiles:        []string{"a.go"},
+				CgoFiles:       []string{"cgo.go"},
+				IgnoredGoFiles: []string{"a_windows.go"},
+				TestGoFiles:    []string{"a_test.go"},
+			},
+		},
+		fs: fs,
+	}
+	keep := PkgSet{"github.com/test/repo/pkg": {}}
+
+	tests := []struct {
+		name string
+		opts GetFileListOptions
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"/src/pkg/a.go"},
+		},
+		{
+			name: "cgo",
+			opts: GetFileListOptions{IncludeCgo: true},
+			want: []string{"/src/pkg/a.go", "/src/pkg/cgo.go"},
+		},
+		{
+			name: "ignored",
+			opts: GetFileListOptions{IncludeIgnored: true},
+			want: []string{"/src/pkg/a.go", "/src/pkg/a_windows.go"},
+		},
+		{
+			name: "everything",
+			opts: GetFileListOptions{WithTests: true, IncludeCgo: true, IncludeIgnored: true},
+			want: []string{"/src/pkg/a.go", "/src/pkg/cgo.go", "/src/pkg/a_windows.go", "/src/pkg/a_test.go"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, f.GetFileList(keep, tt.opts))
+		})
+	}
+
+	assert.Equal(t, []string{"/src/pkg/a.go", "/src/pkg/a_test.go"}, f.GetFileListWithTests(keep, true))
+}
+
+func TestFinder_PackagesFromGitDiff(t *testing.T) {
+	diff := &MockCommand{output: []byte(
+		"pkg1/file.go\n" +
+			"pkg2/testdata/input.txt\n" +
+			"pkg3/sub/file.go\n" +
+			"README.md\n",
+	)}
+	f := &Finder{
+		sourceDir: "/src",
+		packages: map[string]*Package{
+			"github.com/test/repo/pkg1":      {ImportPath: "github.com/test/repo/pkg1", Dir: "/src/pkg1"},
+			"github.com/test/repo/pkg1_test": {ImportPath: "github.com/test/repo/pkg1_test", Dir: "/src/pkg1"},
+			"github.com/test/repo/pkg2":      {ImportPath: "github.com/test/repo/pkg2", Dir: "/src/pkg2"},
+			"github.com/test/repo/pkg3":      {ImportPath: "github.com/test/repo/pkg3", Dir: "/src/pkg3"},
+			"github.com/test/repo/pkg3/sub":  {ImportPath: "github.com/test/repo/pkg3/sub", Dir: "/src/pkg3/sub"},
+			"github.com/test/repo/pkg4":      {ImportPath: "github.com/test/repo/pkg4", Dir: "/src/pkg4"},
+		},
+		commander: &MockCommander{
+			commands: map[string]*MockCommand{
+				"git [diff --name-only --relative HEAD~3..HEAD]": diff,
+			},
+		},
+	}
+
+	pkgs, err := f.PackagesFromGitDiff("HEAD~3..HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, PkgSet{
+		"github.com/test/repo/pkg1":      {},
+		"github.com/test/repo/pkg1_test": {},
+		"github.com/test/repo/pkg2":      {},
+		"github.com/test/repo/pkg3/sub":  {},
+	}, pkgs)
+	assert.Equal(t, "/src", diff.dir)
+
+	f.commander = &MockCommander{
+		commands: map[string]*MockCommand{
+			"git [diff --name-only --relative bad]": {err: errors.New("exit status 128")},
+		},
+	}
+	_, err = f.PackagesFromGitDiff("bad")
+	assert.Error(t, err)
+}
+
+func TestFinder_DeepEmbedScan(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, file := range []string{
+		"/src/web/web.go",
+		"/src/web/assets/index.html",
+		"/src/web/assets/.hidden",
+		"/src/web/assets/_partial.html",
+		"/src/web/assets/css/site.css",
+		"/src/web/templates/page.tmpl",
+		"/src/web/notes.txt",
+	} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte("content"), 0644))
+	}
+
+	newFinder := func(deep bool) *Finder {
+		f := NewFinder("/src", WithDeepEmbedScan(deep))
+		f.fs = fs
+		f.packages = map[string]*Package{
+			"github.com/test/repo/web": {
+				ImportPath:    "github.com/test/repo/web",
+				Dir:           "/src/web",
+				GoFiles:       []string{"web.go"},
+				Imports:       []string{"embed"},
+				EmbedPatterns: []string{"assets", "t*"},
+				EmbedFiles:    []string{"assets/css/site.css", "assets/index.html", "templates/page.tmpl"},
+			},
+		}
+		return f
+	}
+	keep := PkgSet{"github.com/test/repo/web": {}}
+
+	assert.ElementsMatch(t, []string{
+		"/src/web/web.go",
+		"/src/web/assets/css/site.css",
+		"/src/web/assets/index.html",
+		"/src/web/templates/page.tmpl",
+	}, newFinder(false).GetFileList(keep, GetFileListOptions{}))
+
+	assert.ElementsMatch(t, []string{
+		"/src/web/web.go",
+		"/src/web/assets/css/site.css",
+		"/src/web/assets/index.html",
+		"/src/web/assets/.hidden",
+		"/src/web/assets/_partial.html",
+		"/src/web/templates/page.tmpl",
+	}, newFinder(true).GetFileList(keep, GetFileListOptions{}))
+}
+
+// BlockingCommander implements ContextCommander with commands that run until
+// their context is done
+type BlockingCommander struct {
+	MockCommander
+}
+
+func (c *BlockingCommander) CommandContext(ctx context.Context, name string, args ...string) Command {
+	c.calls = append(c.calls, fmt.Sprintf("%s %v", name, args))
+	return &blockingCommand{ctx: ctx}
+}
+
+type blockingCommand struct {
+	MockCommand
+	ctx context.Context
+}
+
+func (c *blockingCommand) Output() ([]byte, error) {
+	<-c.ctx.Done()
+	return nil, errors.New("signal: killed")
+}
+
+func TestFinder_FindAllContext(t *testing.T) {
+	commander := &BlockingCommander{}
+	f := NewFinder("/src")
+	f.commander = commander
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := f.FindAllContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, []string{"go [list -json ./...]"}, commander.calls)
+}
+
+func TestFinder_Explain(t *testing.T) {
+	f := &Finder{
+		sourceDir: "/src",
+		packages: map[string]*Package{
+			"github.com/org/repo/op-node": {
+				ImportPath: "github.com/org/repo/op-node",
+				Dir:        "/src/op-node",
+			},
+			"github.com/org/repo/op-node/service": {
+				ImportPath: "github.com/org/repo/op-node/service",
+				Dir:        "/src/op-node/service",
+				Imports:    []string{"github.com/org/repo/op-node", "github.com/org/repo/util"},
+				Deps:       []string{"github.com/org/repo/log", "github.com/org/repo/op-node", "github.com/org/repo/util"},
+			},
+			"github.com/org/repo/util": {
+				ImportPath: "github.com/org/repo/util",
+				Dir:        "/src/util",
+				Imports:    []string{"github.com/org/repo/log"},
+				Deps:       []string{"github.com/org/repo/log"},
+			},
+			"github.com/org/repo/log": {
+				ImportPath: "github.com/org/repo/log",
+				Dir:        "/src/log",
+			},
+			"github.com/org/repo/unused": {
+				ImportPath: "github.com/org/repo/unused",
+				Dir:        "/src/unused",
+			},
+			"github.com/org/repo/op-node/legacy": {
+				ImportPath: "github.com/org/repo/op-node/legacy",
+				Dir:        "/src/op-node/legacy",
+			},
+		},
+		fs: afero.NewMemMapFs(),
+	}
+
+	patterns := []string{"op-node/...", "!op-node/legacy"}
+	keep := f.FilterByPatterns(patterns)
+	f.FilterByNegation(keep, patterns)
+	f.AddDependencies(keep)
+
+	tests := []struct {
+		importPath string
+		want       string
+	}{
+		{
+			importPath: "github.com/org/repo/op-node",
+			want:       "kept because: matched pattern 'op-node/...'; also required by github.com/org/repo/op-node/service (direct dep)",
+		},
+		{
+			importPath: "github.com/org/repo/op-node/service",
+			want:       "kept because: matched pattern 'op-node/...'",
+		},
+		{
+			importPath: "github.com/org/repo/log",
+			want:       "kept because: required by github.com/org/repo/op-node/service (transitive dep), github.com/org/repo/util (direct dep)",
+		},
+		{
+			importPath: "github.com/org/repo/op-node/legacy",
+			want:       "not kept: removed by pattern '!op-node/legacy'",
+		},
+		{
+			importPath: "github.com/org/repo/unused",
+			want:       "not kept: not matched by any pattern or required by any kept package",
+		},
+		{
+			importPath: "github.com/org/repo/missing",
+			want:       "unknown package github.com/org/repo/missing",
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, f.Explain(tt.importPath), tt.importPath)
+	}
+}
+
+func TestFinder_DepsMode(t *testing.T) {
+	repoPkgs := `
+		{"ImportPath": "github.com/test/repo/a", "Dir": "/test/a", "GoFiles": ["a.go"], "Deps": ["fmt", "github.com/test/repo/b", "github.com/test/repo/c", "golang.org/x/ext"]}
+		{"ImportPath": "github.com/test/repo/b", "Dir": "/test/b", "GoFiles": ["b.go"], "Deps": ["github.com/test/repo/c", "golang.org/x/ext"]}
+		{"ImportPath": "github.com/test/repo/c", "Dir": "/test/c", "GoFiles": ["c.go"]}
+		{"ImportPath": "github.com/test/repo/d", "Dir": "/test/d", "GoFiles": ["d.go"]}
+	`
+	depOnly := `
+		{"ImportPath": "fmt", "Dir": "/usr/local/go/src/fmt", "GoFiles": ["print.go"], "DepOnly": true}
+		{"ImportPath": "golang.org/x/ext", "Dir": "/root/go/pkg/mod/golang.org/x/ext", "GoFiles": ["ext.go"], "DepOnly": true}
+	`
+
+	find := func(t *testing.T, depsMode bool) (*Finder, *MockCommander) {
+		commander := &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [list -json ./...]":          {output: []byte(repoPkgs)},
+				"go [list -e -json -deps ./...]": {output: []byte(depOnly + repoPkgs)},
+			},
+		}
+		f := NewFinder("/test", WithDepsMode(depsMode))
+		f.commander = commander
+		f.fs = afero.NewMemMapFs()
+		require.NoError(t, f.FindAll())
+		return f, commander
+	}
+
+	twoPass, _ := find(t, false)
+	deps, commander := find(t, true)
+	assert.Equal(t, []string{"go [list -e -json -deps ./...]"}, commander.calls)
+
+	// Packages outside the source directory are dropped
+	assert.Equal(t, twoPass.packages, deps.packages)
+
+	for _, roots := range [][]string{
+		{"github.com/test/repo/a"},
+		{"github.com/test/repo/b"},
+		{"github.com/test/repo/b", "github.com/test/repo/d"},
+	} {
+		want := make(map[string]struct{})
+		got := make(map[string]struct{})
+		for _, root := range roots {
+			want[root] = struct{}{}
+			got[root] = struct{}{}
+		}
+		twoPass.AddDependencies(want)
+		deps.AddDependencies(got)
+		assert.Equal(t, want, got, "roots %v", roots)
+	}
+}
+
+func TestFinder_DepsMode_DepOnlyInRepo(t *testing.T) {
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"go [list -e -json -deps ./...]": {output: []byte(`
+				{"ImportPath": "example.com/tools", "Dir": "/test/tools", "GoFiles": ["tools.go"], "DepOnly": true}
+				{"ImportPath": "github.com/test/repo/a", "Dir": "/test/a", "GoFiles": ["a.go"], "Deps": ["example.com/tools"]}
+			`)},
+		},
+	}
+	f := NewFinder("/test", WithDepsMode(true))
+	f.commander = commander
+	f.fs = afero.NewMemMapFs()
+	require.NoError(t, f.FindAll())
+
+	// A dependency living in the source directory is kept even though ./...
+	// does not match it, for instance a module added with a replace directive
+	keep := map[string]struct{}{"github.com/test/repo/a": {}}
+	f.AddDependencies(keep)
+	assert.Equal(t, map[string]struct{}{
+		"github.com/test/repo/a": {},
+		"example.com/tools":      {},
+	}, keep)
+}
+
+func TestFinder_FindAll_BuildTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantCall string
+	}{
+		{
+			name:     "no tags",
+			wantCall: "go [list -json ./...]",
+		},
+		{
+			name:     "tags",
+			opts:     []Option{WithBuildTags([]string{"integration", "linux"})},
+			wantCall: "go [list -tags=integration,linux -json ./...]",
+		},
+		{
+			name:     "tags in deps mode",
+			opts:     []Option{WithBuildTags([]string{"integration"}), WithDepsMode(true)},
+			wantCall: "go [list -tags=integration -e -json -deps ./...]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commander := &MockCommander{
+				commands: map[string]*MockCommand{
+					tt.wantCall: {output: []byte(`{"ImportPath": "github.com/test/repo/pkg1", "Dir": "/test/pkg1"}`)},
+				},
+			}
+			f := NewFinder("/test", tt.opts...)
+			f.commander = commander
+			require.NoError(t, f.FindAll())
+			assert.Equal(t, []string{tt.wantCall}, commander.calls)
+		})
+	}
+}
+
+func TestFinder_IncludePrebuiltBinaries(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for file, perm := range map[string]os.FileMode{
+		"/src/cmd/tool/main.go":   0644,
+		"/src/bin/protoc":         0755,
+		"/src/bin/README.md":      0644,
+		"/src/tools/bin/golint":   0700,
+		"/src/tools/bin/notes":    0600,
+		"/src/scripts/build.sh":   0755,
+		"/src/.git/hooks/pre-bin": 0755,
+	} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte("content"), perm))
+	}
+
+	newFinder := func(enabled bool) *Finder {
+		f := NewFinder("/src", WithIncludePrebuiltBinaries(enabled))
+		f.fs = fs
+		f.packages = map[string]*Package{
+			"github.com/test/repo/cmd/tool": {
+				ImportPath: "github.com/test/repo/cmd/tool",
+				Dir:        "/src/cmd/tool",
+				GoFiles:    []string{"main.go"},
+			},
+		}
+		return f
+	}
+	keep := PkgSet{"github.com/test/repo/cmd/tool": {}}
+
+	assert.ElementsMatch(t, []string{
+		"/src/cmd/tool/main.go",
+	}, newFinder(false).GetFileList(keep, GetFileListOptions{}))
+
+	assert.ElementsMatch(t, []string{
+		"/src/cmd/tool/main.go",
+		"/src/bin/protoc",
+		"/src/tools/bin/golint",
+	}, newFinder(true).GetFileList(keep, GetFileListOptions{}))
+}
+
+func TestFinder_FindAll_ErrorTolerant(t *testing.T) {
+	listOutput := `
+		{"ImportPath": "github.com/test/repo/good", "Dir": "/test/good", "GoFiles": ["good.go"]}
+		{"ImportPath": "github.com/test/repo/broken", "Dir": "/test/broken", "GoFiles": ["broken.go"], "Error": {"ImportStack": ["github.com/test/repo/broken"], "Pos": "broken.go:3:1", "Err": "expected declaration, found oops"}}
+	`
+
+	t.Run("tolerant", func(t *testing.T) {
+		var logs bytes.Buffer
+		log.SetOutput(&logs)
+		defer log.SetOutput(os.Stderr)
+
+		commander := &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [list -e -json ./...]": {output: []byte(listOutput), err: errors.New("exit status 1")},
+			},
+		}
+		f := NewFinder("/test", WithErrorTolerant(true))
+		f.commander = commander
+
+		require.NoError(t, f.FindAll())
+		require.Contains(t, f.packages, "github.com/test/repo/good")
+		require.Contains(t, f.packages, "github.com/test/repo/broken")
+		assert.Nil(t, f.packages["github.com/test/repo/good"].Error)
+		assert.Equal(t, &PackageError{
+			ImportStack: []string{"github.com/test/repo/broken"},
+			Pos:         "broken.go:3:1",
+			Err:         "expected declaration, found oops",
+		}, f.packages["github.com/test/repo/broken"].Error)
+		assert.Contains(t, logs.String(), "Warning: package github.com/test/repo/broken has errors: broken.go:3:1: expected declaration, found oops")
+	})
+
+	t.Run("tolerant without output", func(t *testing.T) {
+		commander := &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [list -e -json ./...]": {err: errors.New("exit status 1")},
+			},
+		}
+		f := NewFinder("/test", WithErrorTolerant(true))
+		f.commander = commander
+
+		assert.EqualError(t, f.FindAll(), "failed to list packages: exit status 1")
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		commander := &MockCommander{
+			commands: map[string]*MockCommand{
+				"go [list -json ./...]": {output: []byte(listOutput), err: errors.New("exit status 1")},
+			},
+		}
+		f := NewFinder("/test")
+		f.commander = commander
+
+		assert.EqualError(t, f.FindAll(), "failed to list packages: exit status 1")
+	})
+}
+
+func TestFinder_FindImporters(t *testing.T) {
+	// a imports b and fmt, b and d import c, c imports errors
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/a": {
+				ImportPath: "github.com/test/repo/a",
+				Imports:    []string{"fmt", "github.com/test/repo/b"},
+				Deps:       []string{"errors", "fmt", "github.com/test/repo/b", "github.com/test/repo/c"},
+			},
+			"github.com/test/repo/b": {
+				ImportPath: "github.com/test/repo/b",
+				Imports:    []string{"github.com/test/repo/c"},
+				Deps:       []string{"errors", "github.com/test/repo/c"},
+			},
+			"github.com/test/repo/c": {
+				ImportPath: "github.com/test/repo/c",
+				Imports:    []string{"errors"},
+				Deps:       []string{"errors"},
+			},
+			"github.com/test/repo/d": {
+				ImportPath: "github.com/test/repo/d",
+				Imports:    []string{"github.com/test/repo/c"},
+				Deps:       []string{"errors", "github.com/test/repo/c"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		importPath string
+		want       []string
+	}{
+		{
+			name:       "direct importers only",
+			importPath: "github.com/test/repo/c",
+			want:       []string{"github.com/test/repo/b", "github.com/test/repo/d"},
+		},
+		{
+			name:       "single importer",
+			importPath: "github.com/test/repo/b",
+			want:       []string{"github.com/test/repo/a"},
+		},
+		{
+			name:       "not imported",
+			importPath: "github.com/test/repo/a",
+		},
+		{
+			name:       "standard library package",
+			importPath: "errors",
+			want:       []string{"github.com/test/repo/c"},
+		},
+		{
+			name:       "unknown package",
+			importPath: "github.com/test/repo/missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, f.FindImporters(tt.importPath))
+		})
+	}
+}
+
+// MockDiscoveryObserver records discovered package batches for testing
+type MockDiscoveryObserver struct {
+	batches [][]*Package
+}
+
+func (o *MockDiscoveryObserver) OnPackagesDiscovered(batch []*Package) {
+	o.batches = append(o.batches, batch)
+}
+
+func TestFinder_FindAll_DiscoveryObserver(t *testing.T) {
+	var listOutput strings.Builder
+	for i := 0; i < 250; i++ {
+		fmt.Fprintf(&listOutput, `{"ImportPath": "github.com/test/repo/pkg%d", "Dir": "/test/pkg%d"}`+"\n", i, i)
+	}
+
+	obs := &MockDiscoveryObserver{}
+	f := NewFinder("/test", WithDiscoveryObserver(obs))
+	f.commander = &MockCommander{
+		commands: map[string]*MockCommand{
+			"go [list -json ./...]": {output: []byte(listOutput.String())},
+		},
+	}
+	require.NoError(t, f.FindAll())
+
+	require.Len(t, obs.batches, 3)
+	assert.Len(t, obs.batches[0], 100)
+	assert.Len(t, obs.batches[1], 100)
+	assert.Len(t, obs.batches[2], 50)
+	assert.Equal(t, "github.com/test/repo/pkg0", obs.batches[0][0].ImportPath)
+	assert.Equal(t, "github.com/test/repo/pkg249", obs.batches[2][49].ImportPath)
+}
+
+func TestFinder_WriteDOT(t *testing.T) {
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/a": {
+				ImportPath: "github.com/test/repo/a",
+				Deps:       []string{"fmt", "github.com/test/repo/b", "github.com/test/repo/c"},
+			},
+			"github.com/test/repo/b": {
+				ImportPath: "github.com/test/repo/b",
+				Deps:       []string{"fmt", "github.com/test/repo/c"},
+			},
+			"github.com/test/repo/c": {
+				ImportPath: "github.com/test/repo/c",
+				Deps:       []string{"golang.org/x/ext"},
+			},
+		},
+	}
+
+	nodeRe := regexp.MustCompile(`(?m)^\t"[^"]+"( \[style=dashed\])?;$`)
+	edgeRe := regexp.MustCompile(`(?m)^\t"[^"]+" -> "[^"]+"( \[style=dashed\])?;$`)
+	count := func(re *regexp.Regexp, dot string) (solid, dashed int) {
+		for _, m := range re.FindAllStringSubmatch(dot, -1) {
+			if m[1] == "" {
+				solid++
+			} else {
+				dashed++
+			}
+		}
+		return solid, dashed
+	}
+
+	tests := []struct {
+		name             string
+		keep             map[string]struct{}
+		wantNodes        int
+		wantExternal     int
+		wantEdges        int
+		wantDashedEdges  int
+		wantContainsEdge string
+	}{
+		{
+			name:             "all packages",
+			wantNodes:        3,
+			wantExternal:     2,
+			wantEdges:        3,
+			wantDashedEdges:  3,
+			wantContainsEdge: `"github.com/test/repo/a" -> "github.com/test/repo/c";`,
+		},
+		{
+			name: "kept packages",
+			keep: map[string]struct{}{
+				"github.com/test/repo/a": {},
+				"github.com/test/repo/b": {},
+			},
+			wantNodes:        2,
+			wantExternal:     1,
+			wantEdges:        1,
+			wantDashedEdges:  2,
+			wantContainsEdge: `"github.com/test/repo/a" -> "fmt" [style=dashed];`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, f.WriteDOT(&buf, tt.keep))
+			dot := buf.String()
+
+			assert.True(t, strings.HasPrefix(dot, "digraph packages {\n"))
+			assert.True(t, strings.HasSuffix(dot, "}\n"))
+
+			nodes, external := count(nodeRe, dot)
+			assert.Equal(t, tt.wantNodes, nodes)
+			assert.Equal(t, tt.wantExternal, external)
+			edges, dashedEdges := count(edgeRe, dot)
+			assert.Equal(t, tt.wantEdges, edges)
+			assert.Equal(t, tt.wantDashedEdges, dashedEdges)
+			assert.Contains(t, dot, tt.wantContainsEdge)
+		})
+	}
+}
+
+func TestFinder_FindCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		packages map[string]*Package
+		want     [][]string
+	}{
+		{
+			name: "acyclic",
+			packages: map[string]*Package{
+				"a": {ImportPath: "a", Deps: []string{"b", "c", "fmt"}},
+				"b": {ImportPath: "b", Deps: []string{"c"}},
+				"c": {ImportPath: "c"},
+			},
+		},
+		{
+			name: "cycle",
+			packages: map[string]*Package{
+				"a": {ImportPath: "a", Deps: []string{"b"}},
+				"b": {ImportPath: "b", Deps: []string{"c"}},
+				"c": {ImportPath: "c", Deps: []string{"a", "fmt"}},
+				"d": {ImportPath: "d", Deps: []string{"a"}},
+			},
+			want: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name: "two cycles",
+			packages: map[string]*Package{
+				"a": {ImportPath: "a", Deps: []string{"b"}},
+				"b": {ImportPath: "b", Deps: []string{"a"}},
+				"c": {ImportPath: "c", Deps: []string{"c"}},
+			},
+			want: [][]string{{"a", "b"}, {"c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Finder{packages: tt.packages}
+			assert.Equal(t, tt.want, f.FindCycles())
+		})
+	}
+}
+
+func TestFinder_AddDependencies_Cycle(t *testing.T) {
+	obs := &MockDependencyObserver{added: make(map[string]string)}
+	f := &Finder{
+		packages: map[string]*Package{
+			"a": {ImportPath: "a", Deps: []string{"b"}},
+			"b": {ImportPath: "b", Deps: []string{"a", "c"}},
+			"c": {ImportPath: "c", Deps: []string{"b"}},
+		},
+		depObserver: obs,
+	}
+
+	keep := map[string]struct{}{"a": {}}
+	f.AddDependencies(keep)
+	assert.Equal(t, map[string]struct{}{"a": {}, "b": {}, "c": {}}, keep)
+	// Each package is added once even though the packages depend on each other
+	assert.Equal(t, map[string]string{"b": "a", "c": "b"}, obs.added)
+}
+
+func TestFinder_ModulePath(t *testing.T) {
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"go [list -json ./...]": {output: []byte(`
+				{"ImportPath": "gitlab.com/org/repo", "Dir": "/test", "Module": {"Path": "gitlab.com/org/repo", "Dir": "/test"}}
+				{"ImportPath": "gitlab.com/org/repo/internal/store", "Dir": "/test/internal/store", "Module": {"Path": "gitlab.com/org/repo", "Dir": "/test"}}
+				{"ImportPath": "gitlab.com/org/repo/internal/store/sql", "Dir": "/test/internal/store/sql", "Module": {"Path": "gitlab.com/org/repo", "Dir": "/test"}}
+				{"ImportPath": "gitlab.com/org/repo/cmd/store", "Dir": "/test/cmd/store", "Module": {"Path": "gitlab.com/org/repo", "Dir": "/test"}}
+				{"ImportPath": "gitlab.com/other/lib/internal/store", "Dir": "/test/third_party/lib/internal/store", "Module": {"Path": "gitlab.com/other/lib", "Dir": "/test/third_party/lib"}}
+			`)},
+		},
+	}
+	f := NewFinder("/test")
+	f.commander = commander
+	require.NoError(t, f.FindAll())
+	assert.Equal(t, "gitlab.com/org/repo", f.ModulePath())
+
+	tests := []struct {
+		pattern string
+		want    map[string]struct{}
+	}{
+		{
+			pattern: "./internal/store",
+			want: map[string]struct{}{
+				"gitlab.com/org/repo/internal/store": {},
+			},
+		},
+		{
+			pattern: "./internal/...",
+			want: map[string]struct{}{
+				"gitlab.com/org/repo/internal/store":     {},
+				"gitlab.com/org/repo/internal/store/sql": {},
+			},
+		},
+		{
+			// Without "./", other modules' packages with the same suffix match
+			pattern: "internal/store",
+			want: map[string]struct{}{
+				"gitlab.com/org/repo/internal/store":  {},
+				"gitlab.com/other/lib/internal/store": {},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			assert.Equal(t, tt.want, f.FilterByPatterns([]string{tt.pattern}))
+		})
+	}
+}
+
+func TestFinder_MinGoVersion(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/src/modern/go.mod", []byte("module github.com/test/modern\n\ngo 1.22.1\n"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/src/legacy/go.mod", []byte("module github.com/test/legacy\n\ngo 1.16\n"), 0644))
+
+	f := &Finder{
+		packages: map[string]*Package{
+			"github.com/test/repo/generic": {
+				ImportPath: "github.com/test/repo/generic",
+				Dir:        "/src/generic",
+				Module:     &Module{Path: "github.com/test/repo", GoVersion: "1.21"},
+			},
+			"github.com/test/repo/old": {
+				ImportPath: "github.com/test/repo/old",
+				Dir:        "/src/old",
+				Module:     &Module{Path: "github.com/test/repo", GoVersion: "1.18"},
+			},
+			"github.com/test/modern/iter": {
+				ImportPath: "github.com/test/modern/iter",
+				Dir:        "/src/modern/iter",
+				Module:     &Module{Path: "github.com/test/modern"},
+				GoMod:      "/src/modern/go.mod",
+			},
+			"github.com/test/legacy/util": {
+				ImportPath: "github.com/test/legacy/util",
+				Dir:        "/src/legacy/util",
+				Module:     &Module{Path: "github.com/test/legacy"},
+				GoMod:      "/src/legacy/go.mod",
+			},
+			"github.com/test/gopath/pkg": {
+				ImportPath: "github.com/test/gopath/pkg",
+				Dir:        "/src/gopath/pkg",
+			},
+		},
+		fs: fs,
+	}
+
+	tests := []struct {
+		minGoVersion string
+		want         []string
+	}{
+		{
+			want: []string{
+				"github.com/test/gopath/pkg",
+				"github.com/test/legacy/util",
+				"github.com/test/modern/iter",
+				"github.com/test/repo/generic",
+				"github.com/test/repo/old",
+			},
+		},
+		{
+			minGoVersion: "1.20",
+			want: []string{
+				"github.com/test/gopath/pkg",
+				"github.com/test/legacy/util",
+				"github.com/test/repo/old",
+			},
+		},
+		{
+			minGoVersion: "1.21",
+			want: []string{
+				"github.com/test/gopath/pkg",
+				"github.com/test/legacy/util",
+				"github.com/test/repo/generic",
+				"github.com/test/repo/old",
+			},
+		},
+		{
+			minGoVersion: "1.22",
+			want: []string{
+				"github.com/test/gopath/pkg",
+				"github.com/test/legacy/util",
+				"github.com/test/repo/generic",
+				"github.com/test/repo/old",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("pinned to "+tt.minGoVersion, func(t *testing.T) {
+			f.minGoVersion = tt.minGoVersion
+			var got []string
+			for pkg := range f.FilterByPatterns([]string{"./..."}) {
+				got = append(got, pkg)
+			}
+			sort.Strings(got)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.True(t, ValidGoVersion("1.21"))
+	assert.True(t, ValidGoVersion("1.21.3"))
+	assert.False(t, ValidGoVersion("go1.21"))
+	assert.False(t, ValidGoVersion("latest"))
+}
+
+func TestFinder_ExcludePackages(t *testing.T) {
+	// app imports noisy and shared, tool imports shared, and shared imports log
+	newFinder := func() *Finder {
+		return &Finder{
+			packages: map[string]*Package{
+				"github.com/test/repo/app": {
+					ImportPath: "github.com/test/repo/app",
+					Dir:        "/src/app",
+					Deps:       []string{"github.com/test/repo/noisy", "github.com/test/repo/shared", "github.com/test/repo/log"},
+				},
+				"github.com/test/repo/tool": {
+					ImportPath: "github.com/test/repo/tool",
+					Dir:        "/src/tool",
+					Deps:       []string{"github.com/test/repo/shared", "github.com/test/repo/log"},
+				},
+				"github.com/test/repo/noisy": {
+					ImportPath: "github.com/test/repo/noisy",
+					Dir:        "/src/noisy",
+				},
+				"github.com/test/repo/shared": {
+					ImportPath: "github.com/test/repo/shared",
+					Dir:        "/src/shared",
+					Deps:       []string{"github.com/test/repo/log"},
+				},
+				"github.com/test/repo/log": {
+					ImportPath: "github.com/test/repo/log",
+					Dir:        "/src/log",
+				},
+			},
+			fs: afero.NewMemMapFs(),
+		}
+	}
+
+	tests := []struct {
+		name        string
+		exclude     []string
+		wantRemoved []string
+		wantKept    []string
+		wantWarned  []string
+	}{
+		{
+			name:        "leaf package",
+			exclude:     []string{"tool"},
+			wantRemoved: []string{"github.com/test/repo/tool"},
+			wantKept: []string{
+				"github.com/test/repo/app",
+				"github.com/test/repo/log",
+				"github.com/test/repo/noisy",
+				"github.com/test/repo/shared",
+			},
+		},
+		{
+			name:        "transitive dependency is kept",
+			exclude:     []string{"log"},
+			wantRemoved: nil,
+			wantKept: []string{
+				"github.com/test/repo/app",
+				"github.com/test/repo/log",
+				"github.com/test/repo/noisy",
+				"github.com/test/repo/shared",
+				"github.com/test/repo/tool",
+			},
+			wantWarned: []string{"github.com/test/repo/log"},
+		},
+		{
+			name:        "dependency of an excluded package only",
+			exclude:     []string{"app", "noisy", "shared"},
+			wantRemoved: []string{"github.com/test/repo/app", "github.com/test/repo/noisy"},
+			wantKept: []string{
+				"github.com/test/repo/log",
+				"github.com/test/repo/shared",
+				"github.com/test/repo/tool",
+			},
+			wantWarned: []string{"github.com/test/repo/shared"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			f := newFinder()
+			keep := f.FilterByPatterns([]string{"./..."})
+			f.AddDependencies(keep)
+			assert.Equal(t, tt.wantRemoved, f.ExcludePackages(keep, tt.exclude))
+
+			var kept []string
+			for pkg := range keep {
+				kept = append(kept, pkg)
+			}
+			sort.Strings(kept)
+			assert.Equal(t, tt.wantKept, kept)
+
+			for _, pkg := range tt.wantWarned {
+				assert.Contains(t, logs.String(), "Warning: keeping excluded package "+pkg)
+			}
+			assert.Equal(t, len(tt.wantWarned), strings.Count(logs.String(), "Warning: keeping excluded package"))
+		})
+	}
+}
+
+func TestFinder_ScanNonGo(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, file := range []string{
+		"/test/api/api.go",
+		"/test/api/service.proto",
+		"/test/api/schema.graphql",
+		"/test/api/notes.txt",
+		"/test/api/v2/types.proto",
+		"/test/store/store.go",
+		"/test/store/store.thrift",
+	} {
+		require.NoError(t, afero.WriteFile(fs, file, []byte("content"), 0644))
+	}
+
+	commander := &MockCommander{
+		commands: map[string]*MockCommand{
+			"go [list -json ./...]": {output: []byte(`
+				{"ImportPath": "github.com/test/repo/api", "Dir": "/test/api", "GoFiles": ["api.go"]}
+				{"ImportPath": "github.com/test/repo/store", "Dir": "/test/store", "GoFiles": ["store.go"]}
+			`)},
+		},
+	}
+	f := NewFinder("/test", WithScanNonGo([]string{".proto", "graphql", ".thrift"}))
+	f.fs = fs
+	f.commander = commander
+	require.NoError(t, f.FindAll())
+
+	// Subdirectories are other packages' business
+	assert.Equal(t, map[string][]string{
+		"github.com/test/repo/api":   {"/test/api/schema.graphql", "/test/api/service.proto"},
+		"github.com/test/repo/store": {"/test/store/store.thrift"},
+	}, f.NonGoFiles())
+
+	assert.Equal(t, []string{
+		"/test/api/schema.graphql",
+		"/test/api/service.proto",
+	}, f.GetNonGoFileList(PkgSet{"github.com/test/repo/api": {}}))
+	assert.Empty(t, f.GetNonGoFileList(PkgSet{"github.com/test/repo/missing": {}}))
+
+	// Non-Go files are listed separately
+	assert.Equal(t, []string{"/test/api/api.go"}, f.GetFileList(PkgSet{"github.com/test/repo/api": {}}, GetFileListOptions{}))
+}
@@ -0,0 +1,119 @@
+package pkglist
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// validPlatforms lists the GOOS/GOARCH pairs supported by the Go toolchain
+// (see go tool dist list)
+var validPlatforms = map[string]struct{}{
+	"aix/ppc64":       {},
+	"android/386":     {},
+	"android/amd64":   {},
+	"android/arm":     {},
+	"android/arm64":   {},
+	"darwin/amd64":    {},
+	"darwin/arm64":    {},
+	"dragonfly/amd64": {},
+	"freebsd/386":     {},
+	"freebsd/amd64":   {},
+	"freebsd/arm":     {},
+	"freebsd/arm64":   {},
+	"freebsd/riscv64": {},
+	"illumos/amd64":   {},
+	"ios/amd64":       {},
+	"ios/arm64":       {},
+	"js/wasm":         {},
+	"linux/386":       {},
+	"linux/amd64":     {},
+	"linux/arm":       {},
+	"linux/arm64":     {},
+	"linux/loong64":   {},
+	"linux/mips":      {},
+	"linux/mips64":    {},
+	"linux/mips64le":  {},
+	"linux/mipsle":    {},
+	"linux/ppc64":     {},
+	"linux/ppc64le":   {},
+	"linux/riscv64":   {},
+	"linux/s390x":     {},
+	"netbsd/386":      {},
+	"netbsd/amd64":    {},
+	"netbsd/arm":      {},
+	"netbsd/arm64":    {},
+	"openbsd/386":     {},
+	"openbsd/amd64":   {},
+	"openbsd/arm":     {},
+	"openbsd/arm64":   {},
+	"openbsd/ppc64":   {},
+	"openbsd/riscv64": {},
+	"plan9/386":       {},
+	"plan9/amd64":     {},
+	"plan9/arm":       {},
+	"solaris/amd64":   {},
+	"wasip1/wasm":     {},
+	"windows/386":     {},
+	"windows/amd64":   {},
+	"windows/arm":     {},
+	"windows/arm64":   {},
+}
+
+// ValidPlatform reports whether goos/goarch is a platform supported by Go
+func ValidPlatform(goos, goarch string) bool {
+	_, ok := validPlatforms[goos+"/"+goarch]
+	return ok
+}
+
+// WithPlatform lists packages as if targeting goos/goarch. An unsupported pair
+// makes FindAll fail.
+func WithPlatform(goos, goarch string) Option {
+	return func(f *Finder) {
+		f.goos = goos
+		f.goarch = goarch
+	}
+}
+
+// WithGoOS lists packages as if targeting goos, by setting GOOS for the go
+// commands run by the Finder
+func WithGoOS(goos string) Option {
+	return func(f *Finder) {
+		f.goos = goos
+	}
+}
+
+// WithGoArch lists packages as if targeting goarch, by setting GOARCH for the
+// go commands run by the Finder
+func WithGoArch(goarch string) Option {
+	return func(f *Finder) {
+		f.goarch = goarch
+	}
+}
+
+// commandEnv returns the environment overrides for go commands. When only one
+// of GOOS and GOARCH is set, the other defaults to the host's for validation.
+func (f *Finder) commandEnv() ([]string, error) {
+	if f.goos == "" && f.goarch == "" {
+		return nil, nil
+	}
+
+	goos, goarch := f.goos, f.goarch
+	if goos == "" {
+		goos = runtime.GOOS
+	}
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	if !ValidPlatform(goos, goarch) {
+		return nil, fmt.Errorf("unsupported platform %s/%s", goos, goarch)
+	}
+
+	var env []string
+	if f.goos != "" {
+		env = append(env, "GOOS="+f.goos)
+	}
+	if f.goarch != "" {
+		env = append(env, "GOARCH="+f.goarch)
+	}
+	return env, nil
+}
@@ -0,0 +1,76 @@
+package pkglist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+	"golang.org/x/mod/modfile"
+)
+
+// WithIgnoreReplace makes FindAll list packages as if the go.mod of the source
+// directory had no replace directives, so that local modules that are about to
+// be deleted are not pulled in through them.
+//
+// The go command has no flag to ignore replace directives, so FindAll instead
+// writes a copy of go.mod without them, along with go.sum, to a temporary
+// directory and points go list at it with GOFLAGS="-mod=mod -modfile=...".
+// This has some limitations:
+//   - only the go.mod of the source directory is rewritten; replace
+//     directives in go.work and in other modules still apply
+//   - the replaced modules must be resolvable at their required versions,
+//     which may need network access to download them
+//   - any GOFLAGS set in the environment is overridden for go list
+func WithIgnoreReplace(enabled bool) Option {
+	return func(f *Finder) {
+		f.ignoreReplace = enabled
+	}
+}
+
+// writeModFileWithoutReplace writes the go.mod of the source directory without
+// its replace directives, and its go.sum if any, to a new temporary directory.
+// It returns the path of the new go.mod; the caller removes its directory.
+func (f *Finder) writeModFileWithoutReplace() (string, error) {
+	goMod := filepath.Join(f.sourceDir, "go.mod")
+	data, err := afero.ReadFile(f.fs, goMod)
+	if err != nil {
+		return "", err
+	}
+	mf, err := modfile.Parse(goMod, data, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range mf.Replace {
+		if err := mf.DropReplace(r.Old.Path, r.Old.Version); err != nil {
+			return "", err
+		}
+	}
+	mf.Cleanup()
+	if data, err = mf.Format(); err != nil {
+		return "", err
+	}
+
+	dir, err := afero.TempDir(f.fs, "", "hatchet-modfile-")
+	if err != nil {
+		return "", err
+	}
+	modFile := filepath.Join(dir, "go.mod")
+	if err := afero.WriteFile(f.fs, modFile, data, 0644); err != nil {
+		f.fs.RemoveAll(dir)
+		return "", err
+	}
+
+	// go reads the checksums for an alternate go.mod from the .sum next to it
+	sum, err := afero.ReadFile(f.fs, filepath.Join(f.sourceDir, "go.sum"))
+	if err == nil {
+		err = afero.WriteFile(f.fs, filepath.Join(dir, "go.sum"), sum, 0644)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		f.fs.RemoveAll(dir)
+		return "", fmt.Errorf("failed to copy go.sum: %v", err)
+	}
+	return modFile, nil
+}
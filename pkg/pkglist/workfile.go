@@ -0,0 +1,67 @@
+package pkglist
+
+import (
+	"fmt"
+	"go/version"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	"golang.org/x/mod/modfile"
+)
+
+// GenerateWorkFile writes to outputPath a go.work file with a use directive
+// for the root of every module in the source directory that contains a kept
+// package. The directories are relative to the source directory, so outputPath
+// should be at the root of the source directory or of a copy of it. The go
+// directive is the highest Go version required by those modules.
+func (f *Finder) GenerateWorkFile(keepPackages PkgSet, outputPath string) error {
+	roots := make(map[string]string) // module root to module path
+	goVersion := ""
+	for importPath := range keepPackages {
+		pkg, ok := f.packages[importPath]
+		if !ok || pkg.GoMod == "" {
+			continue
+		}
+		root := filepath.Dir(pkg.GoMod)
+		rel, err := filepath.Rel(f.sourceDir, root)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		dir := "."
+		if rel != "." {
+			dir = "./" + filepath.ToSlash(rel)
+		}
+		modulePath := ""
+		if pkg.Module != nil {
+			modulePath = pkg.Module.Path
+		}
+		roots[dir] = modulePath
+
+		if v := f.goVersionOf(pkg); v != "" && (goVersion == "" || version.Compare("go"+v, "go"+goVersion) > 0) {
+			goVersion = v
+		}
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("no kept package belongs to a module in %s", f.sourceDir)
+	}
+
+	wf := &modfile.WorkFile{Syntax: &modfile.FileSyntax{}}
+	if goVersion != "" {
+		if err := wf.AddGoStmt(goVersion); err != nil {
+			return err
+		}
+	}
+	dirs := make([]string, 0, len(roots))
+	for dir := range roots {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		wf.AddNewUse(dir, roots[dir])
+	}
+	wf.Cleanup()
+
+	return afero.WriteFile(f.fs, outputPath, modfile.Format(wf.Syntax), 0644)
+}
@@ -0,0 +1,90 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Report describes the outcome of a clean run
+type Report struct {
+	DryRun         bool          `json:"dry_run"`
+	Kept           []string      `json:"kept"`
+	Removed        []string      `json:"removed"`
+	BytesReclaimed int64         `json:"bytes_reclaimed"`
+	Duration       time.Duration `json:"duration"`
+
+	// RemovedPackages maps packages excluded by negation patterns to the
+	// pattern that excluded them
+	RemovedPackages map[string]string `json:"removed_packages,omitempty"`
+}
+
+// TextSummary writes a human-readable summary table of the report to w
+func TextSummary(report *Report, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	removedLabel := "Files removed:"
+	if report.DryRun {
+		removedLabel = "Files to remove:"
+	}
+
+	fmt.Fprintf(tw, "Files kept:\t%d\n", len(report.Kept))
+	fmt.Fprintf(tw, "%s\t%d\n", removedLabel, len(report.Removed))
+	fmt.Fprintf(tw, "Bytes reclaimed:\t%d\n", report.BytesReclaimed)
+	fmt.Fprintf(tw, "Time taken:\t%s\n", report.Duration.Round(time.Millisecond))
+
+	return tw.Flush()
+}
+
+// WriteTreeDiff writes a unified diff between two listings of a directory
+// tree to w, as a single hunk covering the whole tree. Entries are paths
+// relative to the tree root, with directories ending in "/".
+func WriteTreeDiff(w io.Writer, before, after []string) error {
+	before = sortedCopy(before)
+	after = sortedCopy(after)
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case j == len(after) || (i < len(before) && before[i] < after[j]):
+			lines = append(lines, "-"+before[i])
+			i++
+		case i == len(before) || after[j] < before[i]:
+			lines = append(lines, "+"+after[j])
+			j++
+		default:
+			lines = append(lines, " "+before[i])
+			i++
+			j++
+		}
+	}
+
+	fmt.Fprintln(w, "--- before")
+	fmt.Fprintln(w, "+++ after")
+	if len(lines) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "@@ %s %s @@\n", hunkRange("-", len(before)), hunkRange("+", len(after)))
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hunkRange(sign string, n int) string {
+	if n == 0 {
+		return sign + "0,0"
+	}
+	return fmt.Sprintf("%s1,%d", sign, n)
+}
+
+func sortedCopy(s []string) []string {
+	s = append([]string(nil), s...)
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,115 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		report *Report
+		want   []string
+	}{
+		{
+			name: "clean run",
+			report: &Report{
+				Kept:           []string{"/src/a.go", "/src/b.go"},
+				Removed:        []string{"/src/c.go"},
+				BytesReclaimed: 1234,
+				Duration:       1500 * time.Millisecond,
+			},
+			want: []string{
+				"Files kept:       2",
+				"Files removed:    1",
+				"Bytes reclaimed:  1234",
+				"Time taken:       1.5s",
+			},
+		},
+		{
+			name: "dry run",
+			report: &Report{
+				DryRun:  true,
+				Removed: []string{"/src/c.go", "/src/d.go"},
+			},
+			want: []string{
+				"Files kept:       0",
+				"Files to remove:  2",
+				"Bytes reclaimed:  0",
+				"Time taken:       0s",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, TextSummary(tt.report, &buf))
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			assert.Equal(t, tt.want, lines)
+		})
+	}
+}
+
+func TestWriteTreeDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+		want   string
+	}{
+		{
+			name:   "cleanup",
+			before: []string{"go.mod", "pkg1/", "pkg1/a.go", "pkg1/b.go", "pkg2/", "pkg2/c.go"},
+			after:  []string{"pkg1/a.go", "go.mod", "pkg1/"},
+			want: `--- before
++++ after
+@@ -1,6 +1,3 @@
+ go.mod
+ pkg1/
+ pkg1/a.go
+-pkg1/b.go
+-pkg2/
+-pkg2/c.go
+`,
+		},
+		{
+			name:   "added entries",
+			before: []string{"b.go"},
+			after:  []string{"a.go", "b.go"},
+			want: `--- before
++++ after
+@@ -1,1 +1,2 @@
++a.go
+ b.go
+`,
+		},
+		{
+			name:   "everything removed",
+			before: []string{"a.go"},
+			want: `--- before
++++ after
+@@ -1,1 +0,0 @@
+-a.go
+`,
+		},
+		{
+			name: "empty trees",
+			want: "--- before\n+++ after\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, WriteTreeDiff(&buf, tt.before, tt.after))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
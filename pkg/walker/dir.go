@@ -0,0 +1,30 @@
+package walker
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// DirWalker walks the operating system filesystem with filepath.WalkDir. Each
+// entry is stat'ed once for the FileInfo passed to fn; if that fails, for
+// instance because the file was removed since its directory was listed, fn is
+// called with the error instead.
+type DirWalker struct{}
+
+// NewDir creates a DirWalker
+func NewDir() *DirWalker {
+	return &DirWalker{}
+}
+
+func (w *DirWalker) Walk(root string, fn WalkFunc) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(path, nil, err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fn(path, nil, err)
+		}
+		return fn(path, info, nil)
+	})
+}
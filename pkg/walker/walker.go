@@ -0,0 +1,116 @@
+package walker
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// WalkFunc is called for each file and directory visited by a Walker. It has
+// the same semantics as filepath.WalkFunc.
+type WalkFunc = filepath.WalkFunc
+
+// Walker walks a file tree rooted at root, calling fn for each entry
+type Walker interface {
+	Walk(root string, fn WalkFunc) error
+}
+
+// FsWalker walks an afero.Fs sequentially
+type FsWalker struct {
+	fs afero.Fs
+}
+
+// New creates a Walker backed by the given filesystem
+func New(fs afero.Fs) *FsWalker {
+	return &FsWalker{fs: fs}
+}
+
+func (w *FsWalker) Walk(root string, fn WalkFunc) error {
+	return afero.Walk(w.fs, root, fn)
+}
+
+// ConcurrentWalker walks each top-level subdirectory of root in its own
+// goroutine. fn may be called concurrently and must be safe for concurrent use.
+type ConcurrentWalker struct {
+	fs      afero.Fs
+	workers int
+}
+
+// NewConcurrent creates a ConcurrentWalker backed by the given filesystem that
+// walks at most workers subdirectories at once
+func NewConcurrent(fs afero.Fs, workers int) *ConcurrentWalker {
+	if workers < 1 {
+		workers = 1
+	}
+	return &ConcurrentWalker{fs: fs, workers: workers}
+}
+
+func (w *ConcurrentWalker) Walk(root string, fn WalkFunc) error {
+	info, err := lstatIfPossible(w.fs, root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if !info.IsDir() {
+		return fn(root, info, nil)
+	}
+	if err := fn(root, info, nil); err != nil {
+		if errors.Is(err, filepath.SkipDir) {
+			return nil
+		}
+		return err
+	}
+
+	entries, err := afero.ReadDir(w.fs, root)
+	if err != nil {
+		return fn(root, info, err)
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, w.workers)
+	)
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+
+		// Files at the top level are visited directly. On failure no more
+		// subdirectories are started, but those already started finish.
+		if !entry.IsDir() {
+			if err := fn(path, entry, nil); err != nil && !errors.Is(err, filepath.SkipDir) {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				break
+			}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := afero.Walk(w.fs, path, fn); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func lstatIfPossible(afs afero.Fs, path string) (fs.FileInfo, error) {
+	if lfs, ok := afs.(afero.Lstater); ok {
+		info, _, err := lfs.LstatIfPossible(path)
+		return info, err
+	}
+	return afs.Stat(path)
+}
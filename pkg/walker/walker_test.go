@@ -0,0 +1,260 @@
+package walker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFs(t testing.TB, files []string) afero.Fs {
+	memFs := afero.NewMemMapFs()
+	for _, file := range files {
+		require.NoError(t, afero.WriteFile(memFs, file, []byte("test content"), 0644))
+	}
+	return memFs
+}
+
+func collectFiles(t *testing.T, w Walker, root string) []string {
+	var (
+		mu    sync.Mutex
+		files []string
+	)
+	err := w.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			mu.Lock()
+			files = append(files, path)
+			mu.Unlock()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(files)
+	return files
+}
+
+func TestWalkers(t *testing.T) {
+	files := []string{
+		"/src/go.mod",
+		"/src/pkg1/file1.go",
+		"/src/pkg1/sub/file2.go",
+		"/src/pkg2/file3.go",
+	}
+
+	walkers := map[string]func(afero.Fs) Walker{
+		"sequential": func(afs afero.Fs) Walker { return New(afs) },
+		"concurrent": func(afs afero.Fs) Walker { return NewConcurrent(afs, 2) },
+	}
+
+	for name, newWalker := range walkers {
+		t.Run(name, func(t *testing.T) {
+			w := newWalker(newTestFs(t, files))
+			assert.Equal(t, files, collectFiles(t, w, "/src"))
+		})
+	}
+}
+
+func TestConcurrentWalker_SkipDir(t *testing.T) {
+	memFs := newTestFs(t, []string{
+		"/src/keep/file1.go",
+		"/src/vendor/file2.go",
+	})
+
+	var (
+		mu    sync.Mutex
+		files []string
+	)
+	err := NewConcurrent(memFs, 4).Walk("/src", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == "vendor" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			mu.Lock()
+			files = append(files, path)
+			mu.Unlock()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/src/keep/file1.go"}, files)
+}
+
+func TestConcurrentWalker_FileError(t *testing.T) {
+	memFs := newTestFs(t, []string{
+		"/src/a/file1.go",
+		"/src/b.go",
+		"/src/c/file2.go",
+	})
+
+	errFailed := errors.New("failed")
+	var (
+		mu      sync.Mutex
+		visited []string
+	)
+	err := NewConcurrent(memFs, 4).Walk("/src", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == "/src/b.go" {
+			return errFailed
+		}
+		if path == "/src/a/file1.go" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		mu.Lock()
+		visited = append(visited, path)
+		mu.Unlock()
+		return nil
+	})
+	assert.ErrorIs(t, err, errFailed)
+
+	// The subdirectory started before the failure was walked to the end
+	// before Walk returned, and none was started after it
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, visited, "/src/a/file1.go")
+	assert.NotContains(t, visited, "/src/c")
+}
+
+func TestDirWalker(t *testing.T) {
+	root := t.TempDir()
+	var want []string
+	for _, file := range []string{"a.go", "pkg1/b.go", "pkg1/sub/c.go", "pkg2/d.go"} {
+		path := filepath.Join(root, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte("test content"), 0644))
+		want = append(want, path)
+	}
+
+	var files []string
+	err := NewDir().Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		assert.Equal(t, filepath.Base(path), info.Name())
+		assert.Equal(t, int64(len("test content")), info.Size())
+		assert.False(t, info.ModTime().IsZero())
+		files = append(files, path)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, want, files)
+
+	// Agrees with afero.Walk on the OS filesystem
+	assert.Equal(t, collectFiles(t, New(afero.NewOsFs()), root), collectFiles(t, NewDir(), root))
+}
+
+func TestDirWalker_StatError(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{"a.go", "b.go", "c.go"} {
+		require.NoError(t, os.WriteFile(filepath.Join(root, file), []byte("test content"), 0644))
+	}
+
+	// b.go is removed after its directory is listed, so it cannot be stat'ed
+	var (
+		files  []string
+		failed []string
+	)
+	err := NewDir().Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			assert.Nil(t, info)
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+			failed = append(failed, path)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if filepath.Base(path) == "a.go" {
+			require.NoError(t, os.Remove(filepath.Join(root, "b.go")))
+		}
+		files = append(files, path)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, "a.go"), filepath.Join(root, "c.go")}, files)
+	assert.Equal(t, []string{filepath.Join(root, "b.go")}, failed)
+}
+
+func benchmarkFs(b *testing.B) afero.Fs {
+	var files []string
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 50; j++ {
+			files = append(files, fmt.Sprintf("/src/pkg%d/file%d.go", i, j))
+		}
+	}
+	return newTestFs(b, files)
+}
+
+func benchmarkWalker(b *testing.B, w Walker) {
+	noop := func(path string, info fs.FileInfo, err error) error { return err }
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.Walk("/src", noop); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFsWalker(b *testing.B) {
+	benchmarkWalker(b, New(benchmarkFs(b)))
+}
+
+func BenchmarkConcurrentWalker(b *testing.B) {
+	benchmarkWalker(b, NewConcurrent(benchmarkFs(b), 8))
+}
+
+func benchmarkOsDir(b *testing.B) string {
+	root := b.TempDir()
+	for i := 0; i < 20; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("pkg%d", i))
+		require.NoError(b, os.MkdirAll(dir, 0755))
+		for j := 0; j < 50; j++ {
+			require.NoError(b, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d.go", j)), []byte("test content"), 0644))
+		}
+	}
+	return root
+}
+
+func benchmarkOsWalker(b *testing.B, w Walker) {
+	root := benchmarkOsDir(b)
+	visit := func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		_ = info.IsDir()
+		return nil
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := w.Walk(root, visit); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFsWalker_OsFs(b *testing.B) {
+	benchmarkOsWalker(b, New(afero.NewOsFs()))
+}
+
+func BenchmarkDirWalker(b *testing.B) {
+	benchmarkOsWalker(b, NewDir())
+}